@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// settings containing any of these words are hidden from the /config endpoint
+var redactedSettings = []string{"password", "token", "secret"}
+
+// settings that are maps of request headers, whose values are all hidden from the /config endpoint
+// since any of them can carry a credential, like Authorization or Cookie
+var redactedHeaderSettings = []string{"headers"}
+
+const redactedValue = "********"
+
+// StatusServer is the http api used to query the daemon status and the metrics it has collected
+type StatusServer struct {
+	config   Config
+	modules  *Modules
+	started  time.Time
+	listener net.Listener
+}
+
+type healthResponse struct {
+	Status   string    `json:"status"`
+	Uptime   float64   `json:"uptime_seconds"`
+	LastTick time.Time `json:"last_tick"`
+}
+
+type configResponse struct {
+	Config  Config         `json:"config"`
+	Modules []ModuleConfig `json:"modules"`
+}
+
+func startStatusServer(config Config, modules *Modules) (*StatusServer, error) {
+	listener, err := net.Listen("tcp", config.HTTPAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &StatusServer{
+		config:   config,
+		modules:  modules,
+		started:  time.Now(),
+		listener: listener,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", server.handleHealth)
+	mux.HandleFunc("/modules", server.handleModules)
+	mux.HandleFunc("/metrics/latest", server.handleLatestMetrics)
+	mux.HandleFunc("/config", server.handleConfig)
+
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			log.Printf("http api stopped: %v", err)
+		}
+	}()
+
+	log.Printf("http api listening on %s", config.HTTPAddress)
+
+	return server, nil
+}
+
+func (s *StatusServer) Close() error {
+	return s.listener.Close()
+}
+
+// handleHealth reports ok as long as metrics have been collected recently.  Before the first tick
+// completes, or if ticks stop for three intervals, it reports stale with a 503.
+func (s *StatusServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	_, lastTick := s.modules.LatestMetrics()
+
+	health := healthResponse{
+		Status:   "ok",
+		Uptime:   time.Since(s.started).Seconds(),
+		LastTick: lastTick,
+	}
+
+	status := http.StatusOK
+	maxAge := secondsToDuration(s.config.Interval * 3)
+	if time.Since(lastTick) > maxAge && time.Since(s.started) > maxAge {
+		health.Status = "stale"
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, health)
+}
+
+func (s *StatusServer) handleModules(w http.ResponseWriter, r *http.Request) {
+	states := make([]ModuleState, 0, len(s.modules.Statuses))
+	for _, status := range s.modules.Statuses {
+		states = append(states, status.State())
+	}
+
+	writeJSON(w, http.StatusOK, states)
+}
+
+// handleLatestMetrics returns the latest metrics of each input module.  The module parameter limits
+// the response to a single module, and the name parameter is a glob matched against the metric name
+// or its dotted name, e.g. /metrics/latest?module=cpu&name=cpu0.*
+func (s *StatusServer) handleLatestMetrics(w http.ResponseWriter, r *http.Request) {
+	moduleFilter := r.URL.Query().Get("module")
+	nameFilter := r.URL.Query().Get("name")
+
+	if nameFilter != "" {
+		if _, err := path.Match(nameFilter, ""); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid name pattern: %v", err)})
+			return
+		}
+	}
+
+	allMetrics, _ := s.modules.LatestMetrics()
+
+	filtered := make([]*ModuleMetrics, 0, len(allMetrics))
+	for _, module := range allMetrics {
+		if moduleFilter != "" && module.Module != moduleFilter && module.ID() != moduleFilter {
+			continue
+		}
+
+		if nameFilter == "" {
+			filtered = append(filtered, module)
+			continue
+		}
+
+		metrics := make([]Metric, 0, len(module.Metrics))
+		for _, metric := range module.Metrics {
+			nameMatch, _ := path.Match(nameFilter, metric.Name)
+			flatMatch, _ := path.Match(nameFilter, metric.FlatName())
+			if nameMatch || flatMatch {
+				metrics = append(metrics, metric)
+			}
+		}
+		if len(metrics) > 0 {
+			filtered = append(filtered, &ModuleMetrics{Module: module.Module, Instance: module.Instance, Metrics: metrics, Details: module.Details})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, filtered)
+}
+
+func (s *StatusServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	response := configResponse{Config: s.config, Modules: make([]ModuleConfig, 0, len(s.modules.ModuleConfigs))}
+
+	for _, moduleConfig := range s.modules.ModuleConfigs {
+		settings, _ := jsonSettings(moduleConfig.Settings).(map[string]interface{})
+		moduleConfig.Settings = settings
+		response.Modules = append(response.Modules, moduleConfig)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// jsonFloat is a float64 that encodes NaN and the infinities, which json has no form for, as null
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+// jsonSettings converts the maps decoded from yaml, which are keyed by interface{}, into maps that can
+// be encoded as json.  Values of settings that look like credentials are redacted.
+func jsonSettings(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		settings := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			settings[key] = jsonSetting(key, value)
+		}
+		return settings
+	case map[interface{}]interface{}:
+		settings := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			skey := fmt.Sprintf("%v", key)
+			settings[skey] = jsonSetting(skey, value)
+		}
+		return settings
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, value := range v {
+			values = append(values, jsonSettings(value))
+		}
+		return values
+	default:
+		return v
+	}
+}
+
+func jsonSetting(key string, value interface{}) interface{} {
+	for _, word := range redactedSettings {
+		if strings.Contains(strings.ToLower(key), word) {
+			return redactedValue
+		}
+	}
+
+	for _, name := range redactedHeaderSettings {
+		if strings.ToLower(key) == name {
+			return redactedHeaders(value)
+		}
+	}
+
+	return jsonSettings(value)
+}
+
+// redactedHeaders keeps the names of headers and hides their values
+func redactedHeaders(value interface{}) interface{} {
+	headers, ok := jsonSettings(value).(map[string]interface{})
+	if !ok {
+		return redactedValue
+	}
+
+	for name := range headers {
+		headers[name] = redactedValue
+	}
+	return headers
+}
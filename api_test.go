@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestWriteJSONNonFiniteFloats(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		json  string
+	}{
+		{"finite", 1.5, `1.5`},
+		{"nan", math.NaN(), `null`},
+		{"positive infinity", math.Inf(1), `null`},
+		{"negative infinity", math.Inf(-1), `null`},
+	}
+
+	for _, test := range tests {
+		response := []*ModuleMetrics{{
+			Module:  "processes",
+			Metrics: []Metric{NewRateMetric("top_cpu", test.value, map[string]string{"rank": "1"})},
+			Details: map[string]interface{}{"top": []TopProcess{{Rank: 1, Pid: 1, Value: test.value}}},
+		}}
+
+		recorder := httptest.NewRecorder()
+		writeJSON(recorder, http.StatusOK, response)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d: %s", test.name, recorder.Code, recorder.Body.String())
+			continue
+		}
+
+		var decoded []struct {
+			Metrics []map[string]json.RawMessage
+			Details struct {
+				Top []map[string]json.RawMessage
+			}
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+			t.Errorf("%s: unable to decode %s: %v", test.name, recorder.Body.String(), err)
+			continue
+		}
+
+		metric := decoded[0].Metrics[0]
+		if value := string(metric["value"]); value != test.json {
+			t.Errorf("%s: expected metric value %s, got %s", test.name, test.json, value)
+		}
+		if name := string(metric["name"]); name != `"top_cpu"` {
+			t.Errorf("%s: expected the other metric fields to be kept, got name %s", test.name, name)
+		}
+		if typ := string(metric["type"]); typ != `"rate"` {
+			t.Errorf("%s: expected metric type \"rate\", got %s", test.name, typ)
+		}
+		if !strings.Contains(string(metric["tags"]), `"rank"`) {
+			t.Errorf("%s: expected the metric tags to be kept, got %s", test.name, metric["tags"])
+		}
+
+		if value := string(decoded[0].Details.Top[0]["value"]); value != test.json {
+			t.Errorf("%s: expected top process value %s, got %s", test.name, test.json, value)
+		}
+	}
+}
+
+func TestConfigRedactsCredentials(t *testing.T) {
+	settings := make(map[string]interface{})
+	err := yaml.Unmarshal([]byte(`
+password: hunter2
+checks:
+  - name: api
+    url: https://localhost/health
+    headers:
+      Host: api.example.com
+      Authorization: Bearer abc123
+      Cookie: session=def456
+`), &settings)
+	if err != nil {
+		t.Fatalf("unable to parse the settings: %v", err)
+	}
+
+	server := &StatusServer{modules: &Modules{ModuleConfigs: []ModuleConfig{
+		{Name: HTTPCheckModuleName, Enabled: true, Settings: settings},
+	}}}
+
+	recorder := httptest.NewRecorder()
+	server.handleConfig(recorder, httptest.NewRequest("GET", "/config", nil))
+	body := recorder.Body.String()
+
+	for _, secret := range []string{"hunter2", "abc123", "def456", "api.example.com"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("/config shows %q:\n%s", secret, body)
+		}
+	}
+
+	var decoded configResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("unable to decode %s: %v", body, err)
+	}
+	check := decoded.Modules[0].Settings["checks"].([]interface{})[0].(map[string]interface{})
+	if check["url"] != "https://localhost/health" {
+		t.Errorf("got url %v, want the other check settings to be kept", check["url"])
+	}
+	headers := check["headers"].(map[string]interface{})
+	for _, name := range []string{"Host", "Authorization", "Cookie"} {
+		if headers[name] != "********" {
+			t.Errorf("header %s = %v, want it to be redacted", name, headers[name])
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CgroupsModuleName = "cgroups"
+
+func init() {
+	RegisterModule(CgroupsModuleName, func() Module { return &CgroupsInputModule{} },
+		ModuleSetting{"include", "globs of the cgroup paths to report, relative to the cgroup root, all of them by default"},
+		ModuleSetting{"exclude", "globs of the cgroup paths to skip"},
+		ModuleSetting{"max_depth", "how many levels below the cgroup root to walk, defaults to 2"},
+		ModuleSetting{"memory_stat", "memory.stat fields to report, defaults to a common set"},
+	)
+}
+
+const defaultCgroupsMaxDepth = 2
+
+// memory.stat fields reported by default
+var defaultCgroupMemoryStats = []string{
+	"anon",
+	"file",
+	"kernel_stack",
+	"slab",
+	"sock",
+	"shmem",
+	"file_mapped",
+	"file_dirty",
+	"file_writeback",
+	"pgfault",
+	"pgmajfault",
+}
+
+// cumulative memory.stat fields that are reported as per second rates
+var cgroupMemoryCounters = []string{
+	"pgfault",
+	"pgmajfault",
+	"pgrefill",
+	"pgscan",
+	"pgsteal",
+	"pgactivate",
+	"pgdeactivate",
+	"pglazyfree",
+	"pglazyfreed",
+	"workingset_refault_anon",
+	"workingset_refault_file",
+	"workingset_activate_anon",
+	"workingset_activate_file",
+	"workingset_restore_anon",
+	"workingset_restore_file",
+	"workingset_nodereclaim",
+	"thp_fault_alloc",
+	"thp_collapse_alloc",
+}
+
+type CgroupsInputModule struct {
+	Include        []string
+	Exclude        []string
+	MaxDepth       int
+	MemoryStats    StringSet
+	memoryCounters StringSet
+	cgroupPath     string
+	blockPath      string
+	rates          CounterRates
+}
+
+func (m *CgroupsInputModule) Name() string {
+	return CgroupsModuleName
+}
+
+func (m *CgroupsInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	include, _ := moduleConfig.SettingsStringArray("include")
+	exclude, _ := moduleConfig.SettingsStringArray("exclude")
+	for _, pattern := range append(include, exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Fatalf("Unable to parse cgroup glob %s: %v", pattern, err)
+		}
+	}
+
+	maxDepth, err := moduleConfig.SettingsInt("max_depth")
+	if err != nil || maxDepth <= 0 {
+		maxDepth = defaultCgroupsMaxDepth
+	}
+
+	memoryStats, err := moduleConfig.SettingsStringArray("memory_stat")
+	if err != nil {
+		memoryStats = defaultCgroupMemoryStats
+	}
+
+	m.Include = include
+	m.Exclude = exclude
+	m.MaxDepth = maxDepth
+	m.MemoryStats.AddAll(memoryStats)
+	m.memoryCounters.AddAll(cgroupMemoryCounters)
+	m.cgroupPath = config.HostSys("fs", "cgroup")
+	m.blockPath = config.HostSys("dev", "block")
+
+	// cgroup v1 hierarchies have a directory per controller rather than cgroup.controllers
+	_, err = os.Stat(filepath.Join(m.cgroupPath, "cgroup.controllers"))
+	if err != nil {
+		log.Printf("%s doesn't look like a cgroup v2 hierarchy: %v", m.cgroupPath, err)
+	}
+
+	return nil
+}
+
+func (m *CgroupsInputModule) TearDown() error {
+	return nil
+}
+
+func (m *CgroupsInputModule) GetMetrics() (*ModuleMetrics, error) {
+	now := time.Now()
+	metrics := make([]Metric, 0, 256)
+
+	cgroups, err := m.cgroups()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]string)
+
+	for _, cgroup := range cgroups {
+		dir := filepath.Join(m.cgroupPath, cgroup)
+		tags := map[string]string{"cgroup": cgroup}
+
+		// a cgroup can be removed at any point while it's read, in which case the files that are gone
+		// are skipped and its counters are forgotten on the next collection
+		m.cpuCounters(dir, tags)
+		metrics = append(metrics, m.memoryMetrics(dir, tags)...)
+		m.ioCounters(dir, tags, devices)
+		metrics = append(metrics, cgroupLimitMetrics(dir, "pids", tags)...)
+	}
+
+	metrics = append(metrics, m.rates.Rates(now)...)
+
+	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+}
+
+// cgroups walks the cgroup hierarchy down to the max depth, returning the paths of the cgroups that
+// match the include and exclude globs, relative to the cgroup root
+func (m *CgroupsInputModule) cgroups() ([]string, error) {
+	cgroups := make([]string, 0, 64)
+
+	err := filepath.Walk(m.cgroupPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the cgroup was removed during the walk, or can't be read
+			if path != m.cgroupPath {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+
+		cgroup, err := filepath.Rel(m.cgroupPath, path)
+		if err != nil || cgroup == "." {
+			return nil
+		}
+
+		depth := strings.Count(cgroup, string(filepath.Separator)) + 1
+		if m.matches(cgroup) {
+			cgroups = append(cgroups, cgroup)
+		}
+		if depth >= m.MaxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return cgroups, err
+}
+
+func (m *CgroupsInputModule) matches(cgroup string) bool {
+	for _, pattern := range m.Exclude {
+		if matched, _ := filepath.Match(pattern, cgroup); matched {
+			return false
+		}
+	}
+
+	if len(m.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range m.Include {
+		if matched, _ := filepath.Match(pattern, cgroup); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// cpuCounters records the cpu time and throttling counters from cpu.stat.  cpu time is reported as
+// the percent of a single cpu used, so a cgroup using two cpus reports 200.
+func (m *CgroupsInputModule) cpuCounters(dir string, tags map[string]string) {
+	stats, err := parseCgroupKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return
+	}
+
+	cpuTimes := map[string]string{
+		"usage_usec":  "cpu_usage",
+		"user_usec":   "cpu_user",
+		"system_usec": "cpu_system",
+	}
+	for field, name := range cpuTimes {
+		if value, ok := stats[field]; ok {
+			m.rates.Add(Counter{Name: name, Tags: tags, Value: value, Scale: 100 / 1e6})
+		}
+	}
+
+	throttling := []string{"nr_periods", "nr_throttled", "throttled_usec"}
+	for _, field := range throttling {
+		if value, ok := stats[field]; ok {
+			m.rates.Add(Counter{Name: "cpu_" + field + "_per_second", Tags: tags, Value: value})
+		}
+	}
+}
+
+// memoryMetrics returns memory.current and memory.max, and the configured memory.stat fields.
+// Cumulative memory.stat fields are recorded as counters instead.
+func (m *CgroupsInputModule) memoryMetrics(dir string, tags map[string]string) []Metric {
+	metrics := cgroupLimitMetrics(dir, "memory", tags)
+
+	stats, err := parseCgroupKeyValues(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return metrics
+	}
+
+	for field, value := range stats {
+		if !m.MemoryStats.Contains(field) {
+			continue
+		}
+
+		if m.memoryCounters.Contains(field) {
+			m.rates.Add(Counter{Name: "memory_" + field + "_per_second", Tags: tags, Value: value})
+		} else {
+			metrics = append(metrics, NewTaggedMetric("memory_"+field, value, tags))
+		}
+	}
+
+	return metrics
+}
+
+// ioCounters records the bytes and operations of each device in io.stat, e.g.
+// 8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func (m *CgroupsInputModule) ioCounters(dir string, tags map[string]string, devices map[string]string) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "io.stat"))
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		device, ok := devices[fields[0]]
+		if !ok {
+			device = m.deviceName(fields[0])
+			devices[fields[0]] = device
+		}
+		deviceTags := MergeTags(tags, map[string]string{"device": device})
+
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				continue
+			}
+
+			m.rates.Add(Counter{Name: "io_" + kv[0] + "_per_second", Tags: deviceTags, Value: value})
+		}
+	}
+}
+
+// deviceName returns the name of a block device from its major:minor number, e.g. sda for 8:0, or
+// the number itself if the device can't be found
+func (m *CgroupsInputModule) deviceName(number string) string {
+	b, err := ioutil.ReadFile(filepath.Join(m.blockPath, number, "uevent"))
+	if err != nil {
+		return number
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "DEVNAME=") {
+			return strings.TrimPrefix(line, "DEVNAME=")
+		}
+	}
+	return number
+}
+
+// cgroupLimitMetrics returns the current usage of a controller, e.g. memory.current, and its max
+// unless it's unlimited
+func cgroupLimitMetrics(dir string, controller string, tags map[string]string) []Metric {
+	metrics := make([]Metric, 0, 2)
+
+	for _, name := range []string{"current", "max"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, controller+"."+name))
+		if err != nil {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+		if err != nil {
+			// max is the string max when there's no limit
+			continue
+		}
+
+		metrics = append(metrics, NewTaggedMetric(controller+"_"+name, value, tags))
+	}
+
+	return metrics
+}
+
+// parseCgroupKeyValues parses a flat keyed cgroup file like cpu.stat or memory.stat
+func parseCgroupKeyValues(path string) (map[string]float64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func initCgroups(t *testing.T, sysPath string, settings map[string]interface{}) *CgroupsInputModule {
+	m := &CgroupsInputModule{}
+	if err := m.Init(&Config{SysPath: sysPath}, &ModuleConfig{Name: CgroupsModuleName, Settings: settings}); err != nil {
+		t.Fatalf("Init() returned %v", err)
+	}
+	return m
+}
+
+func TestCgroupsIncludeExcludeAndDepth(t *testing.T) {
+	sysPath := t.TempDir()
+	cgroupPath := filepath.Join(sysPath, "fs", "cgroup")
+	writeFixture(t, filepath.Join(cgroupPath, "cgroup.controllers"), "cpu io memory pids\n")
+	for _, cgroup := range []string{
+		"init.scope",
+		"system.slice/a.service/nested",
+		"system.slice/b.service",
+		"system.slice/c.scope",
+		"user.slice/user-1000.slice",
+	} {
+		if err := os.MkdirAll(filepath.Join(cgroupPath, cgroup), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		cgroups  []string
+	}{
+		{"defaults", map[string]interface{}{}, []string{
+			"init.scope",
+			"system.slice",
+			"system.slice/a.service",
+			"system.slice/b.service",
+			"system.slice/c.scope",
+			"user.slice",
+			"user.slice/user-1000.slice",
+		}},
+		{"max_depth 1", map[string]interface{}{"max_depth": 1}, []string{
+			"init.scope",
+			"system.slice",
+			"user.slice",
+		}},
+		{"max_depth 3", map[string]interface{}{"max_depth": 3, "include": []interface{}{"system.slice/*/*"}}, []string{
+			"system.slice/a.service/nested",
+		}},
+		{"include", map[string]interface{}{"include": []interface{}{"system.slice/*", "user.slice"}}, []string{
+			"system.slice/a.service",
+			"system.slice/b.service",
+			"system.slice/c.scope",
+			"user.slice",
+		}},
+		{"include and exclude", map[string]interface{}{
+			"include": []interface{}{"system.slice/*"},
+			"exclude": []interface{}{"*.scope", "*/*.scope"},
+		}, []string{
+			"system.slice/a.service",
+			"system.slice/b.service",
+		}},
+	}
+
+	for _, test := range tests {
+		m := initCgroups(t, sysPath, test.settings)
+		cgroups, err := m.cgroups()
+		if err != nil || !reflect.DeepEqual(cgroups, test.cgroups) {
+			t.Errorf("%s: cgroups() = %v, %v, want %v", test.name, cgroups, err, test.cgroups)
+		}
+	}
+}
+
+// writeCgroup writes the stat files of a cgroup, with cpu, memory, and io counters that are scale
+// times their starting values
+func writeCgroup(t *testing.T, cgroupPath string, cgroup string, scale float64) {
+	value := func(start float64) string {
+		return strconv.FormatFloat(start*scale, 'f', -1, 64)
+	}
+
+	dir := filepath.Join(cgroupPath, cgroup)
+	files := map[string]string{
+		"cpu.stat":       "usage_usec " + value(1000000) + "\nuser_usec 600000\nsystem_usec 400000\nnr_periods 0\nnr_throttled 0\nthrottled_usec 0\n",
+		"memory.current": "1048576\n",
+		"memory.max":     "max\n",
+		"memory.stat":    "anon 4096\nfile 8192\npgfault " + value(100) + "\nunreported 1\n",
+		"io.stat":        "8:0 rbytes=" + value(10240) + " wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n259:0 rbytes=0 wbytes=0 rios=0 wios=0 dbytes=0 dios=0\n",
+		"pids.current":   "3\n",
+		"pids.max":       "100\n",
+	}
+	for name, content := range files {
+		writeFixture(t, filepath.Join(dir, name), content)
+	}
+}
+
+func TestCgroupsGetMetrics(t *testing.T) {
+	sysPath := t.TempDir()
+	cgroupPath := filepath.Join(sysPath, "fs", "cgroup")
+	writeFixture(t, filepath.Join(cgroupPath, "cgroup.controllers"), "cpu io memory pids\n")
+	writeFixture(t, filepath.Join(sysPath, "dev", "block", "8:0", "uevent"), "MAJOR=8\nMINOR=0\nDEVNAME=sda\nDEVTYPE=disk\n")
+	writeCgroup(t, cgroupPath, "a.service", 1)
+	writeCgroup(t, cgroupPath, "b.service", 1)
+
+	m := initCgroups(t, sysPath, map[string]interface{}{})
+
+	moduleMetrics, err := m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+
+	// memory.max is unlimited, and only the default memory.stat fields are reported
+	got := make(map[string]float64)
+	for _, metric := range moduleMetrics.Metrics {
+		got[metric.FlatName()] = metric.Value
+	}
+	want := map[string]float64{}
+	for _, cgroup := range []string{"a.service", "b.service"} {
+		want[cgroup+".memory_current"] = 1048576
+		want[cgroup+".memory_anon"] = 4096
+		want[cgroup+".memory_file"] = 8192
+		want[cgroup+".pids_current"] = 3
+		want[cgroup+".pids_max"] = 100
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got metrics %v, want %v", got, want)
+	}
+
+	// b.service is removed and a.service doubles its counters, ten seconds later
+	if err := os.RemoveAll(filepath.Join(cgroupPath, "b.service")); err != nil {
+		t.Fatal(err)
+	}
+	writeCgroup(t, cgroupPath, "a.service", 2)
+	m.rates.previousTime = m.rates.previousTime.Add(-10 * time.Second)
+
+	moduleMetrics, err = m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+
+	rates := map[string]float64{
+		"a.service.cpu_usage":                  10,
+		"a.service.cpu_user":                   0,
+		"a.service.memory_pgfault_per_second":  10,
+		"a.service.sda.io_rbytes_per_second":   1024,
+		"a.service.sda.io_rios_per_second":     0,
+		"a.service.259:0.io_rbytes_per_second": 0,
+	}
+	got = make(map[string]float64)
+	for _, metric := range moduleMetrics.Metrics {
+		if metric.Tags["cgroup"] != "a.service" {
+			t.Errorf("got %s tagged %v after its cgroup was removed", metric.Name, metric.Tags)
+		}
+		if _, ok := metric.Tags["device"]; ok && metric.Tags["device"] != "sda" && metric.Tags["device"] != "259:0" {
+			t.Errorf("%s has device %s, want sda or the number of a device without a name", metric.Name, metric.Tags["device"])
+		}
+		got[metric.FlatName()] = metric.Value
+	}
+	for name, rate := range rates {
+		value, ok := got[name]
+		if !ok || math.Abs(value-rate) > 0.01*rate {
+			t.Errorf("%s = %v, want %v", name, value, rate)
+		}
+	}
+}
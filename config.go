@@ -2,23 +2,43 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 // Config stores all the config options for sysminerd
 type Config struct {
-	Interval   float64
-	Hostname   string
-	ConfigPath string `yaml:"config_path"`
+	Interval    float64           `json:"interval"`
+	Hostname    string            `json:"hostname"`
+	ConfigPath  string            `yaml:"config_path" json:"config_path"`
+	Tags        map[string]string `json:"tags"`
+	HTTPAddress string            `yaml:"http_address" json:"http_address"`
+	// CollectionTimeout is how long a tick waits for the input modules, in seconds
+	CollectionTimeout float64 `yaml:"collection_timeout" json:"collection_timeout,omitempty"`
+	// ShutdownTimeout is how long to wait for the final tick and output flushes on exit, in seconds
+	ShutdownTimeout float64 `yaml:"shutdown_timeout" json:"shutdown_timeout,omitempty"`
+	// ProcPath, SysPath and RootfsPath are where the host's /proc, /sys and / are mounted, for running
+	// in a container or against a fixture tree
+	ProcPath   string `yaml:"proc_path" json:"proc_path,omitempty"`
+	SysPath    string `yaml:"sys_path" json:"sys_path,omitempty"`
+	RootfsPath string `yaml:"rootfs_path" json:"rootfs_path,omitempty"`
 }
 
 type ModuleConfig struct {
-	Name     string
-	Enabled  bool
-	Settings map[string]interface{}
+	Name     string                 `json:"name"`
+	Instance string                 `json:"instance,omitempty"`
+	Enabled  bool                   `json:"enabled"`
+	Interval float64                `json:"interval,omitempty"`
+	Timeout  float64                `json:"timeout,omitempty"`
+	Inputs   []string               `json:"inputs,omitempty"`
+	Settings map[string]interface{} `json:"settings"`
 }
 
 func parseConfig(path string) Config {
@@ -38,6 +58,76 @@ func parseConfig(path string) Config {
 	return yamlConfig
 }
 
+// getHostname returns the hostname from the config, falling back to the system hostname or the first
+// interface address if one isn't set.
+func getHostname(config *Config) string {
+	if config.Hostname != "" {
+		return config.Hostname
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil || len(addrs) == 0 {
+			log.Printf("Unable to get the system hostname: %v", err)
+			hostname = "unknown"
+		} else {
+			hostname = addrs[0].String()
+		}
+	}
+
+	return hostname
+}
+
+// ID returns the name the module is known by, which includes the instance when one is configured,
+// e.g. redis.cache
+func (config *ModuleConfig) ID() string {
+	return moduleID(config.Name, config.Instance)
+}
+
+func moduleID(name string, instance string) string {
+	if instance == "" {
+		return name
+	}
+	return name + "." + instance
+}
+
+// secondsToDuration converts an interval in seconds from the config into a duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// HostProc returns the path of a file under proc_path, or /proc if it isn't set
+func (config *Config) HostProc(elem ...string) string {
+	return hostPath(config.ProcPath, "/proc", elem)
+}
+
+// HostProcSelf returns the path of a per process file, like mounts or net/dev.  When proc_path is set
+// the file is read for pid 1, so it describes the host rather than the container sysminerd runs in.
+func (config *Config) HostProcSelf(elem ...string) string {
+	if config.ProcPath == "" {
+		return hostPath("", "/proc", elem)
+	}
+	return hostPath(config.ProcPath, "", append([]string{"1"}, elem...))
+}
+
+// HostSys returns the path of a file under sys_path, or /sys if it isn't set
+func (config *Config) HostSys(elem ...string) string {
+	return hostPath(config.SysPath, "/sys", elem)
+}
+
+// HostRootfs returns the path of a host file under rootfs_path, or the path itself if it isn't set
+func (config *Config) HostRootfs(elem ...string) string {
+	return hostPath(config.RootfsPath, "/", elem)
+}
+
+func hostPath(root string, defaultRoot string, elem []string) string {
+	if root == "" {
+		root = defaultRoot
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
 func parseModuleConfig(path string) ModuleConfig {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -100,3 +190,73 @@ func (config *ModuleConfig) SettingsStringArray(key string) ([]string, error) {
 	}
 	return values, nil
 }
+
+func (config *ModuleConfig) SettingsFloat(key string) (float64, error) {
+	value, ok := config.Settings[key]
+	if !ok {
+		return 0, errors.New("Key does not exist")
+	}
+	switch fvalue := value.(type) {
+	case int:
+		return float64(fvalue), nil
+	case float64:
+		return fvalue, nil
+	default:
+		return 0, errors.New("value is not a number")
+	}
+}
+
+func (config *ModuleConfig) SettingsBool(key string) (bool, error) {
+	value, ok := config.Settings[key]
+	if !ok {
+		return false, errors.New("Key does not exist")
+	}
+	bvalue, ok := value.(bool)
+	if !ok {
+		return false, errors.New("value is not a bool")
+	}
+	return bvalue, nil
+}
+
+// SettingsStringMap returns a map setting with its keys and values as strings
+func (config *ModuleConfig) SettingsStringMap(key string) (map[string]string, error) {
+	value, ok := config.Settings[key]
+	if !ok {
+		return nil, errors.New("Key does not exist")
+	}
+	mvalue, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("value is not a map")
+	}
+	values := make(map[string]string, len(mvalue))
+	for k, v := range mvalue {
+		values[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", v)
+	}
+	return values, nil
+}
+
+// SettingsMapArray returns each map in an array setting as a ModuleConfig, so the values of the map
+// can be read with the same Settings helpers.
+func (config *ModuleConfig) SettingsMapArray(key string) ([]ModuleConfig, error) {
+	avalue, err := config.SettingsArray(key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]ModuleConfig, 0, len(avalue))
+	for _, v := range avalue {
+		mvalue, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("value is not an array of maps")
+		}
+		settings := make(map[string]interface{}, len(mvalue))
+		for k, sv := range mvalue {
+			skey, ok := k.(string)
+			if !ok {
+				return nil, errors.New("map key is not a string")
+			}
+			settings[skey] = sv
+		}
+		values = append(values, ModuleConfig{Name: config.Name, Settings: settings})
+	}
+	return values, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectorsReadProcPath(t *testing.T) {
+	procPath := t.TempDir()
+	writeFixture(t, filepath.Join(procPath, "meminfo"), `MemTotal:       16000 kB
+MemFree:         4000 kB
+Buffers:         1000 kB
+Cached:          2000 kB
+SwapTotal:       8000 kB
+SwapFree:        8000 kB
+`)
+	writeFixture(t, filepath.Join(procPath, "stat"), `cpu  100 0 100 800 0 0 0 0 0 0
+cpu0 100 0 100 800 0 0 0 0 0 0
+intr 0
+`)
+	config := &Config{ProcPath: procPath}
+
+	memory := &MemoryInputModule{}
+	memory.Init(config, &ModuleConfig{Name: MemoryModuleName})
+	moduleMetrics, err := memory.GetMetrics()
+	if err != nil {
+		t.Fatalf("memory GetMetrics() returned %v", err)
+	}
+	values := make(map[string]float64)
+	for _, metric := range moduleMetrics.Metrics {
+		values[metric.Name] = metric.Value
+	}
+	if values["total"] != 16000*1024 || values["used"] != 9000*1024 || values["swap_free"] != 8000*1024 {
+		t.Errorf("got memory metrics %v, want the ones from the fixture meminfo", values)
+	}
+
+	cpu := &CPUInputModule{}
+	cpu.Init(config, &ModuleConfig{Name: CpuModuleName})
+	if _, err := cpu.GetMetrics(); err != nil {
+		t.Fatalf("cpu GetMetrics() returned %v", err)
+	}
+	writeFixture(t, filepath.Join(procPath, "stat"), `cpu  150 0 100 850 0 0 0 0 0 0
+cpu0 150 0 100 850 0 0 0 0 0 0
+intr 0
+`)
+	moduleMetrics, err = cpu.GetMetrics()
+	if err != nil {
+		t.Fatalf("cpu GetMetrics() returned %v", err)
+	}
+	found := false
+	for _, metric := range moduleMetrics.Metrics {
+		if metric.Name == "user" && metric.Tags["cpu"] == "cpu0" {
+			found = true
+			if metric.Value != 50 {
+				t.Errorf("cpu0 user = %v, want 50 from the fixture stat", metric.Value)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("got cpu metrics %v, want cpu0 from the fixture stat", moduleMetrics.Metrics)
+	}
+}
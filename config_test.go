@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHostPaths(t *testing.T) {
+	unset := &Config{}
+	set := &Config{ProcPath: "/host/proc", SysPath: "/host/sys/", RootfsPath: "/host"}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"HostProc default", unset.HostProc("stat"), "/proc/stat"},
+		{"HostProc", set.HostProc("stat"), "/host/proc/stat"},
+		{"HostProc nested", set.HostProc("sys", "fs", "file-nr"), "/host/proc/sys/fs/file-nr"},
+		{"HostProc root", set.HostProc(), "/host/proc"},
+		{"HostProcSelf default", unset.HostProcSelf("net", "dev"), "/proc/net/dev"},
+		{"HostProcSelf uses pid 1", set.HostProcSelf("net", "dev"), "/host/proc/1/net/dev"},
+		{"HostProcSelf mounts", set.HostProcSelf("mounts"), "/host/proc/1/mounts"},
+		{"HostSys default", unset.HostSys("block"), "/sys/block"},
+		{"HostSys trailing slash", set.HostSys("block", "sda", "stat"), "/host/sys/block/sda/stat"},
+		{"HostRootfs default", unset.HostRootfs("/var/lib"), "/var/lib"},
+		{"HostRootfs", set.HostRootfs("/var/lib"), "/host/var/lib"},
+		{"HostRootfs root", set.HostRootfs("/"), "/host"},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestNetworkReadsPid1WithProcPath(t *testing.T) {
+	procPath := t.TempDir()
+	dev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0: %s    10    0    0    0     0          0         0     2000      20    0    0    0     0       0          0
+`
+	// the host's interfaces are those of pid 1, not of the container sysminerd runs in
+	writeFixture(t, filepath.Join(procPath, "1", "net", "dev"), fmt.Sprintf(dev, "1000"))
+	writeFixture(t, filepath.Join(procPath, "net", "dev"), strings.Replace(fmt.Sprintf(dev, "1"), "eth0", "veth0", 1))
+
+	m := &NetworkInputModule{}
+	m.Init(&Config{ProcPath: procPath}, &ModuleConfig{Name: NetworkModuleName})
+	if _, err := m.GetMetrics(); err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+
+	writeFixture(t, filepath.Join(procPath, "1", "net", "dev"), fmt.Sprintf(dev, "1500"))
+	moduleMetrics, err := m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, metric := range moduleMetrics.Metrics {
+		values[metric.FlatName()] = metric.Value
+	}
+	if values["eth0.rx_bytes"] != 500 || values["eth0.tx_bytes"] != 0 {
+		t.Errorf("got network metrics %v, want eth0 from pid 1", values)
+	}
+	if _, ok := values["veth0.rx_bytes"]; ok {
+		t.Error("the interfaces of sysminerd's own network namespace were reported")
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"strconv"
 	"strings"
@@ -9,6 +8,10 @@ import (
 
 const CpuModuleName = "cpu"
 
+func init() {
+	RegisterModule(CpuModuleName, func() Module { return &CPUInputModule{} })
+}
+
 // CPU Fields for /proc/stat
 var cpuFields = map[string]int{
 	"user":       1,
@@ -24,6 +27,7 @@ var cpuFields = map[string]int{
 }
 
 type CPUInputModule struct {
+	statPath         string
 	previousCPUStats map[string][]float64
 }
 
@@ -32,6 +36,7 @@ func (m *CPUInputModule) Name() string {
 }
 
 func (m *CPUInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	m.statPath = config.HostProc("stat")
 	return nil
 }
 
@@ -73,11 +78,12 @@ func (m *CPUInputModule) ParseProcStat(content string) (*ModuleMetrics, error) {
 
 	if m.previousCPUStats != nil {
 		for cpu, values := range cpus {
+			tags := map[string]string{"cpu": cpu}
 			totalDiff := values[0] - m.previousCPUStats[cpu][0]
 			for name, index := range cpuFields {
 				value := values[index] - m.previousCPUStats[cpu][index]
 
-				metric := NewMetric(fmt.Sprintf("%s.%s", cpu, name), (value/totalDiff)*100)
+				metric := NewTaggedMetric(name, (value/totalDiff)*100, tags)
 
 				metrics = append(metrics, metric)
 			}
@@ -8,7 +8,7 @@ import (
 
 func (m *CPUInputModule) GetMetrics() (*ModuleMetrics, error) {
 
-	b, err := ioutil.ReadFile("/proc/stat")
+	b, err := ioutil.ReadFile(m.statPath)
 	if err != nil {
 		return nil, err
 	}
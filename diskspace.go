@@ -20,10 +20,18 @@ type FilesystemStats struct {
 
 const DiskspaceModuleName = "diskspace"
 
+func init() {
+	RegisterModule(DiskspaceModuleName, func() Module { return &DiskspaceInputModule{} },
+		ModuleSetting{"filesystems", "filesystem types to report, defaults to common disk filesystems"},
+	)
+}
+
 var defaultCheckTypes = []string{"ext2", "ext3", "ext4", "xfs", "glusterfs", "nfs", "ntfs", "hfs", "fat32", "fat16", "btrfs"}
 
 type DiskspaceInputModule struct {
 	CheckTypes StringSet
+	mountsPath string
+	rootfsPath string
 }
 
 func (m *DiskspaceInputModule) Name() string {
@@ -42,6 +50,9 @@ func (m *DiskspaceInputModule) Init(config *Config, moduleConfig *ModuleConfig)
 	log.Printf("types: %v", types)
 	m.CheckTypes.AddAll(types)
 
+	m.mountsPath = config.HostProcSelf("mounts")
+	m.rootfsPath = config.HostRootfs()
+
 	return nil
 }
 
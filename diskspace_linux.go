@@ -3,7 +3,6 @@
 package main
 
 import (
-	"fmt"
 	"golang.org/x/sys/unix" //see https://godoc.org/golang.org/x/sys/unix
 	"io/ioutil"
 	"log"
@@ -19,17 +18,19 @@ func (m *DiskspaceInputModule) GetMetrics() (*ModuleMetrics, error) {
 		log.Printf("Error retrieving filesystems: %v", err)
 		return nil, err
 	}
-	stats, err := GetFilesystemStats(filesystems)
+	stats, err := GetFilesystemStats(m, filesystems)
 	if err != nil {
 		log.Printf("Error retrieving filesystem stats: %v", err)
 		return nil, err
 	}
 
 	for _, stat := range stats {
-		used := NewMetric(fmt.Sprintf("%s.used", stat.DeviceName), stat.Used)
-		free := NewMetric(fmt.Sprintf("%s.free", stat.DeviceName), stat.Free)
-		reserved := NewMetric(fmt.Sprintf("%s.reserved", stat.DeviceName), stat.Reserved)
-		available := NewMetric(fmt.Sprintf("%s.available", stat.DeviceName), stat.Available)
+		tags := map[string]string{"device": stat.DeviceName}
+
+		used := NewTaggedMetric("used", stat.Used, tags)
+		free := NewTaggedMetric("free", stat.Free, tags)
+		reserved := NewTaggedMetric("reserved", stat.Reserved, tags)
+		available := NewTaggedMetric("available", stat.Available, tags)
 
 		metrics = append(metrics, used)
 		metrics = append(metrics, free)
@@ -41,7 +42,7 @@ func (m *DiskspaceInputModule) GetMetrics() (*ModuleMetrics, error) {
 }
 
 func GetFileSystems(m *DiskspaceInputModule) ([]Filesystem, error) {
-	b, err := ioutil.ReadFile("/proc/mounts")
+	b, err := ioutil.ReadFile(m.mountsPath)
 	if err != nil {
 		return nil, err
 	}
@@ -70,13 +71,13 @@ func GetFileSystems(m *DiskspaceInputModule) ([]Filesystem, error) {
 			continue
 		}
 
-		deviceSym, err := filepath.EvalSymlinks(device)
-		if err == nil {
-			device = deviceSym
+		// devices and mounts are host paths, found under the rootfs when running in a container
+		if strings.HasPrefix(device, "/") {
+			device = resolveHostSymlink(m.rootfsPath, device)
 		}
 
 		stat := unix.Stat_t{}
-		err = unix.Stat(mount, &stat)
+		err := unix.Stat(filepath.Join(m.rootfsPath, mount), &stat)
 		if err != nil {
 			// filesystem likely not mounted
 			continue
@@ -94,12 +95,26 @@ func GetFileSystems(m *DiskspaceInputModule) ([]Filesystem, error) {
 	return filesystems, nil
 }
 
-func GetFilesystemStats(filesystems []Filesystem) ([]FilesystemStats, error) {
+// resolveHostSymlink follows a symlink such as /dev/disk/by-uuid/... within the rootfs, returning the
+// host path it points to, or the path unchanged if it can't be resolved
+func resolveHostSymlink(rootfsPath string, path string) string {
+	target, err := filepath.EvalSymlinks(filepath.Join(rootfsPath, path))
+	if err != nil {
+		return path
+	}
+	target, err = filepath.Rel(rootfsPath, target)
+	if err != nil || strings.HasPrefix(target, "..") {
+		return path
+	}
+	return filepath.Join("/", target)
+}
+
+func GetFilesystemStats(m *DiskspaceInputModule, filesystems []Filesystem) ([]FilesystemStats, error) {
 	stats := make([]FilesystemStats, 0, len(filesystems))
 
 	for _, fs := range filesystems {
 		stat := unix.Statfs_t{}
-		err := unix.Statfs(fs.Mount, &stat)
+		err := unix.Statfs(filepath.Join(m.rootfsPath, fs.Mount), &stat)
 		if err != nil {
 			continue
 		}
@@ -3,7 +3,6 @@
 package main
 
 import (
-	"fmt"
 	"strconv"
 	"syscall"
 	"unsafe"
@@ -28,9 +27,11 @@ func (m *DiskspaceInputModule) GetMetrics() (*ModuleMetrics, error) {
 
 		usedBytes := lpTotalNumberOfBytes - lpTotalNumberOfFreeBytes
 
-		used := NewMetric(fmt.Sprintf("%s.used", drive[:1]), float64(usedBytes))
-		free := NewMetric(fmt.Sprintf("%s.free", drive[:1]), float64(lpTotalNumberOfFreeBytes))
-		available := NewMetric(fmt.Sprintf("%s.available", drive[:1]), float64(lpFreeBytesAvailable))
+		tags := map[string]string{"device": drive[:1]}
+
+		used := NewTaggedMetric("used", float64(usedBytes), tags)
+		free := NewTaggedMetric("free", float64(lpTotalNumberOfFreeBytes), tags)
+		available := NewTaggedMetric("available", float64(lpFreeBytesAvailable), tags)
 
 		metrics = append(metrics, used)
 		metrics = append(metrics, free)
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"io/ioutil"
 	"strconv"
 	"strings"
@@ -24,7 +23,12 @@ type DiskStats struct {
 
 const DiskusageModuleName = "diskusage"
 
+func init() {
+	RegisterModule(DiskusageModuleName, func() Module { return &DiskusageInputModule{} })
+}
+
 type DiskusageInputModule struct {
+	diskstatsPath     string
 	previousDiskStats map[string]DiskStats
 	previousTime      time.Time
 }
@@ -34,6 +38,7 @@ func (m *DiskusageInputModule) Name() string {
 }
 
 func (m *DiskusageInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	m.diskstatsPath = config.HostProc("diskstats")
 	return nil
 }
 
@@ -46,7 +51,7 @@ func (m *DiskusageInputModule) GetMetrics() (*ModuleMetrics, error) {
 	now := time.Now()
 	timeDiff := time.Since(m.previousTime).Seconds()
 
-	allStats, err := GetDiskStats("/proc/diskstats")
+	allStats, err := GetDiskStats(m.diskstatsPath)
 	if err != nil {
 		return nil, err
 	}
@@ -63,12 +68,14 @@ func (m *DiskusageInputModule) GetMetrics() (*ModuleMetrics, error) {
 				readBytesPerSecond := ((stats.ReadsSectors - previous.ReadsSectors) * 512) / timeDiff
 				writeBytesPerSecond := ((stats.WritesSectors - previous.WritesSectors) * 512) / timeDiff
 
-				metrics = append(metrics, NewMetric(fmt.Sprintf("%s.reads", device), readsPerSecond))
-				metrics = append(metrics, NewMetric(fmt.Sprintf("%s.writes", device), writesPerSecond))
-				metrics = append(metrics, NewMetric(fmt.Sprintf("%s.reads_merged", device), readsMergedPerSecond))
-				metrics = append(metrics, NewMetric(fmt.Sprintf("%s.writes_merged", device), writesMergedPerSecond))
-				metrics = append(metrics, NewMetric(fmt.Sprintf("%s.read_bytes", device), readBytesPerSecond))
-				metrics = append(metrics, NewMetric(fmt.Sprintf("%s.write_bytes", device), writeBytesPerSecond))
+				tags := map[string]string{"device": device}
+
+				metrics = append(metrics, NewRateMetric("reads", readsPerSecond, tags))
+				metrics = append(metrics, NewRateMetric("writes", writesPerSecond, tags))
+				metrics = append(metrics, NewRateMetric("reads_merged", readsMergedPerSecond, tags))
+				metrics = append(metrics, NewRateMetric("writes_merged", writesMergedPerSecond, tags))
+				metrics = append(metrics, NewRateMetric("read_bytes", readBytesPerSecond, tags))
+				metrics = append(metrics, NewRateMetric("write_bytes", writeBytesPerSecond, tags))
 			}
 		}
 	}
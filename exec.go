@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ExecModuleName = "exec"
+
+func init() {
+	RegisterModule(ExecModuleName, func() Module { return &ExecInputModule{} },
+		ModuleSetting{"timeout", "default seconds a command may run before it is killed, defaults to 10"},
+		ModuleSetting{"commands", "list of commands, each with a name, command, format (graphite, influx, or nagios), and optional timeout and interval"},
+	)
+}
+
+const defaultExecTimeout = 10 * time.Second
+
+// how long to wait for a killed command to exit before giving up on it
+const execKillGracePeriod = time.Second
+
+const (
+	GraphiteExecFormat = "graphite"
+	InfluxExecFormat   = "influx"
+	NagiosExecFormat   = "nagios"
+)
+
+// nagios perfdata value with an optional unit of measure, e.g. 0.25s or 80%
+var nagiosValuePattern = regexp.MustCompile(`^(-?[0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)([a-zA-Z%]*)$`)
+
+// scale of nagios units of measure to seconds and bytes
+var nagiosUnits = map[string]float64{
+	"":   1,
+	"%":  1,
+	"c":  1,
+	"s":  1,
+	"ms": 1e-3,
+	"us": 1e-6,
+	"B":  1,
+	"KB": 1024,
+	"MB": 1024 * 1024,
+	"GB": 1024 * 1024 * 1024,
+	"TB": 1024 * 1024 * 1024 * 1024,
+}
+
+// ExecCommand is a command run by the exec module, whose output is parsed into metrics
+type ExecCommand struct {
+	Name     string
+	Command  string
+	Format   string
+	Timeout  time.Duration
+	Interval time.Duration
+	lastRun  time.Time
+}
+
+type ExecInputModule struct {
+	Commands []*ExecCommand
+}
+
+func (m *ExecInputModule) Name() string {
+	return ExecModuleName
+}
+
+func (m *ExecInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	defaultTimeout := defaultExecTimeout
+	timeout, err := moduleConfig.SettingsFloat("timeout")
+	if err == nil && timeout > 0 {
+		defaultTimeout = secondsToDuration(timeout)
+	}
+
+	commandConfigs, err := moduleConfig.SettingsMapArray("commands")
+	if err != nil {
+		log.Fatalf("commands must be specified: %v", err)
+	}
+
+	names := StringSet{}
+	m.Commands = make([]*ExecCommand, 0, len(commandConfigs))
+
+	for _, commandConfig := range commandConfigs {
+		command := &ExecCommand{Timeout: defaultTimeout}
+
+		command.Name, err = commandConfig.SettingsString("name")
+		if err != nil || command.Name == "" {
+			log.Fatalf("exec command name must be specified: %v", err)
+		}
+		if names.Contains(command.Name) {
+			log.Fatalf("exec command %s is specified more than once", command.Name)
+		}
+		names.Add(command.Name)
+
+		command.Command, err = commandConfig.SettingsString("command")
+		if err != nil || command.Command == "" {
+			log.Fatalf("exec command must be specified for %s: %v", command.Name, err)
+		}
+
+		command.Format, err = commandConfig.SettingsString("format")
+		if err != nil || command.Format == "" {
+			command.Format = GraphiteExecFormat
+		}
+		if command.Format != GraphiteExecFormat && command.Format != InfluxExecFormat && command.Format != NagiosExecFormat {
+			log.Fatalf("exec format %s is not supported", command.Format)
+		}
+
+		timeout, err := commandConfig.SettingsFloat("timeout")
+		if err == nil && timeout > 0 {
+			command.Timeout = secondsToDuration(timeout)
+		}
+
+		interval, err := commandConfig.SettingsFloat("interval")
+		if err == nil && interval > 0 {
+			command.Interval = secondsToDuration(interval)
+		}
+
+		m.Commands = append(m.Commands, command)
+	}
+
+	return nil
+}
+
+func (m *ExecInputModule) TearDown() error {
+	return nil
+}
+
+// GetMetrics runs every command that is due at the same time, and waits for them to finish or be
+// killed at their timeout.
+func (m *ExecInputModule) GetMetrics() (*ModuleMetrics, error) {
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	results := make([][]Metric, len(m.Commands))
+
+	for i, command := range m.Commands {
+		// commands with their own interval are skipped until it has passed, allowing for some jitter
+		if command.Interval > 0 && now.Sub(command.lastRun) < command.Interval*9/10 {
+			continue
+		}
+		command.lastRun = now
+
+		wg.Add(1)
+		go func(i int, command *ExecCommand) {
+			defer wg.Done()
+			results[i] = command.Run()
+		}(i, command)
+	}
+
+	wg.Wait()
+
+	metrics := make([]Metric, 0, 48)
+	for _, result := range results {
+		metrics = append(metrics, result...)
+	}
+
+	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+}
+
+// Run executes the command and parses its output.  Metrics are tagged with the command name, and a
+// status metric holds the exit code of the command, or -1 if it couldn't be run or was killed.
+func (c *ExecCommand) Run() []Metric {
+	start := time.Now()
+	tags := map[string]string{"command": c.Name}
+	status := -1
+
+	output, err := c.execute()
+	if err != nil {
+		log.Printf("exec command %s failed: %v", c.Name, err)
+	}
+
+	var metrics []Metric
+	if output != nil {
+		status = output.ExitCode
+
+		var parseErr error
+		switch c.Format {
+		case InfluxExecFormat:
+			metrics, parseErr = parseInfluxOutput(output.Stdout, start)
+		case NagiosExecFormat:
+			metrics, parseErr = parseNagiosOutput(output.Stdout, start)
+		default:
+			metrics, parseErr = parseGraphiteOutput(output.Stdout, start)
+		}
+		if parseErr != nil {
+			log.Printf("exec command %s output: %v", c.Name, parseErr)
+		}
+	}
+
+	for i := range metrics {
+		metrics[i].Tags = MergeTags(metrics[i].Tags, tags)
+	}
+
+	metrics = append(metrics, NewTaggedMetric("status", float64(status), tags))
+	metrics = append(metrics, NewTaggedMetric("duration", time.Since(start).Seconds(), tags))
+
+	return metrics
+}
+
+type execOutput struct {
+	Stdout   string
+	ExitCode int
+}
+
+// execute runs the command, killing it and all of its children if it runs past its timeout.  The
+// output is nil if the command couldn't be started or didn't exit after it was killed.
+func (c *ExecCommand) execute() (*execOutput, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	cmd := shellCommand(c.Command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-done:
+	case <-timer.C:
+		killCommand(cmd)
+
+		// give up on the command if it won't exit, the buffers can't be read while it's running
+		select {
+		case <-done:
+		case <-time.After(execKillGracePeriod):
+			return nil, fmt.Errorf("killed after %v but did not exit", c.Timeout)
+		}
+		return nil, fmt.Errorf("killed after %v", c.Timeout)
+	}
+
+	if stderr.Len() > 0 {
+		log.Printf("exec command %s stderr: %s", c.Name, strings.TrimSpace(stderr.String()))
+	}
+
+	output := &execOutput{Stdout: stdout.String(), ExitCode: cmd.ProcessState.ExitCode()}
+	if output.ExitCode >= 0 {
+		// a non zero exit code is reported by the status metric, not as an error
+		err = nil
+	}
+
+	return output, err
+}
+
+// parseGraphiteOutput parses lines in the graphite plaintext format, name value [timestamp]
+func parseGraphiteOutput(output string, now time.Time) ([]Metric, error) {
+	metrics, invalid := parseGraphitePlaintext(output, now)
+	return metrics, invalidLinesError(invalid)
+}
+
+// parseInfluxOutput parses lines in the influxdb line protocol.  Each numeric field becomes a metric
+// named measurement.field, or just measurement for a field named value, and string fields are
+// ignored.
+func parseInfluxOutput(output string, now time.Time) ([]Metric, error) {
+	metrics := make([]Metric, 0, 8)
+	invalid := 0
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lineMetrics, err := parseInfluxLine(line, now)
+		if err != nil {
+			invalid++
+			continue
+		}
+		metrics = append(metrics, lineMetrics...)
+	}
+
+	return metrics, invalidLinesError(invalid)
+}
+
+func parseInfluxLine(line string, now time.Time) ([]Metric, error) {
+	parts := splitUnescaped(line, ' ', -1)
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, errors.New("invalid line")
+	}
+
+	// splitting drops empty parts, so a line starting with a tag has to be caught first
+	key := splitUnescaped(parts[0], ',', -1)
+	measurement := unescapeInflux(key[0])
+	if measurement == "" || strings.HasPrefix(parts[0], ",") {
+		return nil, errors.New("missing measurement")
+	}
+
+	var tags map[string]string
+	if len(key) > 1 {
+		tags = make(map[string]string, len(key)-1)
+		for _, tag := range key[1:] {
+			kv := splitUnescaped(tag, '=', 2)
+			if len(kv) != 2 {
+				return nil, errors.New("invalid tag")
+			}
+			tags[unescapeInflux(kv[0])] = unescapeInflux(kv[1])
+		}
+	}
+
+	timestamp := now
+	if len(parts) == 3 {
+		ns, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		timestamp = time.Unix(0, ns)
+	}
+
+	metrics := make([]Metric, 0, 4)
+	for _, field := range splitUnescaped(parts[1], ',', -1) {
+		kv := splitUnescaped(field, '=', 2)
+		if len(kv) != 2 {
+			return nil, errors.New("invalid field")
+		}
+
+		value, ok := influxFieldValue(kv[1])
+		if !ok {
+			continue
+		}
+
+		name := unescapeInflux(kv[0])
+		if name != "value" {
+			name = fmt.Sprintf("%s.%s", measurement, name)
+		} else {
+			name = measurement
+		}
+
+		metric := NewTaggedMetric(name, value, tags)
+		metric.Timestamp = timestamp
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+// influxFieldValue converts float, integer, and boolean field values.  String values aren't metrics.
+func influxFieldValue(value string) (float64, bool) {
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true
+	}
+
+	if strings.HasPrefix(value, "\"") {
+		return 0, false
+	}
+
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "i"), "u")
+	fvalue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return fvalue, true
+}
+
+// splitUnescaped splits on separators that aren't backslash escaped or inside double quotes, into at
+// most n parts if n is positive.  Empty parts from repeated separators are dropped.
+func splitUnescaped(value string, separator byte, n int) []string {
+	parts := make([]string, 0, 4)
+	inQuote := false
+	start := 0
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\':
+			i++
+		case value[i] == '"':
+			inQuote = !inQuote
+		case value[i] == separator && !inQuote && (n <= 0 || len(parts) < n-1):
+			if i > start {
+				parts = append(parts, value[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(value) {
+		parts = append(parts, value[start:])
+	}
+
+	return parts
+}
+
+func unescapeInflux(value string) string {
+	for _, c := range []string{",", "=", " ", "\""} {
+		value = strings.Replace(value, "\\"+c, c, -1)
+	}
+	return value
+}
+
+// parseNagiosOutput parses the performance data of nagios plugin output.  Perfdata follows a | on
+// the first line of output, and on the lines after a | in the long output.  Each label becomes a
+// metric, along with label.warn, label.crit, label.min, and label.max when they are numbers, and
+// values are scaled to seconds and bytes by their unit of measure.
+func parseNagiosOutput(output string, now time.Time) ([]Metric, error) {
+	metrics := make([]Metric, 0, 8)
+	invalid := 0
+
+	perfdata := make([]string, 0, 4)
+	longPerfdata := false
+	for i, line := range strings.Split(output, "\n") {
+		if longPerfdata {
+			perfdata = append(perfdata, line)
+			continue
+		}
+
+		index := strings.Index(line, "|")
+		if index < 0 {
+			continue
+		}
+		perfdata = append(perfdata, line[index+1:])
+
+		// after the first line a | starts perfdata that runs to the end of the output
+		if i > 0 {
+			longPerfdata = true
+		}
+	}
+
+	for _, data := range perfdata {
+		for _, item := range splitNagiosPerfdata(data) {
+			itemMetrics, err := parseNagiosPerfdata(item, now)
+			if err != nil {
+				invalid++
+				continue
+			}
+			metrics = append(metrics, itemMetrics...)
+		}
+	}
+
+	return metrics, invalidLinesError(invalid)
+}
+
+// splitNagiosPerfdata splits perfdata on spaces, keeping quoted labels like 'used space'=10 together
+func splitNagiosPerfdata(data string) []string {
+	items := make([]string, 0, 4)
+	inQuote := false
+	start := 0
+
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == '\'':
+			inQuote = !inQuote
+		case data[i] == ' ' && !inQuote:
+			if i > start {
+				items = append(items, data[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(data) {
+		items = append(items, data[start:])
+	}
+
+	return items
+}
+
+// parseNagiosPerfdata parses a single 'label'=value[UOM];[warn];[crit];[min];[max]
+func parseNagiosPerfdata(item string, now time.Time) ([]Metric, error) {
+	index := strings.LastIndex(item, "=")
+	if index < 1 {
+		return nil, errors.New("invalid perfdata")
+	}
+
+	label := item[:index]
+	if strings.HasPrefix(label, "'") && strings.HasSuffix(label, "'") && len(label) > 1 {
+		label = strings.Replace(label[1:len(label)-1], "''", "'", -1)
+	}
+	label = strings.Join(strings.Fields(label), "_")
+
+	values := strings.Split(item[index+1:], ";")
+
+	match := nagiosValuePattern.FindStringSubmatch(values[0])
+	if match == nil {
+		return nil, errors.New("invalid perfdata value")
+	}
+	scale, ok := nagiosUnits[match[2]]
+	if !ok {
+		scale = 1
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return nil, err
+	}
+
+	metric := NewMetric(label, value*scale)
+	metric.Timestamp = now
+	metrics := []Metric{metric}
+
+	// thresholds can be ranges, only plain numbers are reported
+	for i, suffix := range []string{"warn", "crit", "min", "max"} {
+		if i+1 >= len(values) {
+			break
+		}
+		threshold, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			continue
+		}
+		metric := NewMetric(fmt.Sprintf("%s.%s", label, suffix), threshold*scale)
+		metric.Timestamp = now
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func invalidLinesError(invalid int) error {
+	if invalid == 0 {
+		return nil
+	}
+	return fmt.Errorf("skipped %d invalid lines", invalid)
+}
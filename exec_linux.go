@@ -0,0 +1,20 @@
+// +build linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs the command with the shell in its own process group, so the command and any
+// children it starts can be killed together.
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+func killCommand(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
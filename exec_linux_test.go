@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExecCommandRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		format   string
+		timeout  time.Duration
+		status   float64
+		expected map[string]float64
+	}{
+		{
+			name:     "success",
+			command:  "echo 'app.requests 10'",
+			format:   GraphiteExecFormat,
+			status:   0,
+			expected: map[string]float64{"app.requests": 10},
+		},
+		{
+			name:     "non zero exit keeps the output",
+			command:  "echo 'CRITICAL | used=95%;80;90'; exit 2",
+			format:   NagiosExecFormat,
+			status:   2,
+			expected: map[string]float64{"used": 95, "used.warn": 80, "used.crit": 90},
+		},
+		{
+			name:     "command not found",
+			command:  "/nonexistent/command",
+			format:   GraphiteExecFormat,
+			status:   127,
+			expected: map[string]float64{},
+		},
+		{
+			name:     "killed at the timeout with its children",
+			command:  "echo 'app.requests 10'; sleep 10 & sleep 10",
+			format:   GraphiteExecFormat,
+			timeout:  100 * time.Millisecond,
+			status:   -1,
+			expected: map[string]float64{},
+		},
+	}
+
+	for _, test := range tests {
+		command := &ExecCommand{Name: "test", Command: test.command, Format: test.format, Timeout: test.timeout}
+		if command.Timeout == 0 {
+			command.Timeout = 5 * time.Second
+		}
+
+		start := time.Now()
+		metrics := command.Run()
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("%s: expected the command to finish or be killed, took %v", test.name, elapsed)
+		}
+
+		values := make(map[string]float64)
+		for _, metric := range metrics {
+			if metric.Tags["command"] != "test" {
+				t.Errorf("%s: expected %s to be tagged with the command, got %v", test.name, metric.Name, metric.Tags)
+			}
+			values[metric.Name] = metric.Value
+		}
+
+		if values["status"] != test.status {
+			t.Errorf("%s: expected status %v, got %v", test.name, test.status, values["status"])
+		}
+		if _, ok := values["duration"]; !ok {
+			t.Errorf("%s: expected a duration metric", test.name)
+		}
+
+		delete(values, "status")
+		delete(values, "duration")
+		if len(values) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, values)
+		}
+		for name, value := range test.expected {
+			if values[name] != value {
+				t.Errorf("%s: expected %s to be %v, got %v", test.name, name, value, values[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// execTestMetric is the part of a parsed metric the exec parser tests compare
+type execTestMetric struct {
+	Name      string
+	Value     float64
+	Tags      map[string]string
+	Timestamp int64
+}
+
+func execTestMetrics(metrics []Metric) []execTestMetric {
+	simple := make([]execTestMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		simple = append(simple, execTestMetric{metric.Name, metric.Value, metric.Tags, metric.Timestamp.UnixNano()})
+	}
+	return simple
+}
+
+var execTestNow = time.Unix(1500000000, 0)
+
+func TestParseGraphiteOutput(t *testing.T) {
+	now := execTestNow.UnixNano()
+
+	tests := []struct {
+		name     string
+		output   string
+		expected []execTestMetric
+		invalid  bool
+	}{
+		{
+			name:     "value without timestamp",
+			output:   "app.requests 10\n",
+			expected: []execTestMetric{{"app.requests", 10, nil, now}},
+		},
+		{
+			name:     "value with timestamp",
+			output:   "app.requests 10.5 1400000000\n",
+			expected: []execTestMetric{{"app.requests", 10.5, nil, 1400000000e9}},
+		},
+		{
+			name:     "blank lines and extra whitespace",
+			output:   "\n  app.a   1  \n\napp.b\t2\n",
+			expected: []execTestMetric{{"app.a", 1, nil, now}, {"app.b", 2, nil, now}},
+		},
+		{
+			name:     "missing value",
+			output:   "app.requests\napp.ok 1\n",
+			expected: []execTestMetric{{"app.ok", 1, nil, now}},
+			invalid:  true,
+		},
+		{
+			name:     "non numeric value",
+			output:   "app.requests ten\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+		{
+			name:     "non numeric timestamp",
+			output:   "app.requests 10 yesterday\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+		{
+			name:     "too many fields",
+			output:   "app.requests 10 1400000000 extra\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+	}
+
+	for _, test := range tests {
+		metrics, err := parseGraphiteOutput(test.output, execTestNow)
+		if (err != nil) != test.invalid {
+			t.Errorf("%s: expected invalid %v, got error %v", test.name, test.invalid, err)
+		}
+		if actual := execTestMetrics(metrics); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestParseInfluxOutput(t *testing.T) {
+	now := execTestNow.UnixNano()
+
+	tests := []struct {
+		name     string
+		output   string
+		expected []execTestMetric
+		invalid  bool
+	}{
+		{
+			name:     "value field",
+			output:   "queue value=5\n",
+			expected: []execTestMetric{{"queue", 5, nil, now}},
+		},
+		{
+			name:   "tags, fields and timestamp",
+			output: "queue,name=jobs,host=a depth=5,workers=2i 1400000000000000000\n",
+			expected: []execTestMetric{
+				{"queue.depth", 5, map[string]string{"name": "jobs", "host": "a"}, 1400000000e9},
+				{"queue.workers", 2, map[string]string{"name": "jobs", "host": "a"}, 1400000000e9},
+			},
+		},
+		{
+			name:   "booleans and unsigned integers",
+			output: "service up=t,down=false,count=3u\n",
+			expected: []execTestMetric{
+				{"service.up", 1, nil, now},
+				{"service.down", 0, nil, now},
+				{"service.count", 3, nil, now},
+			},
+		},
+		{
+			name:     "string fields are skipped",
+			output:   `service status="ok, all good",up=1` + "\n",
+			expected: []execTestMetric{{"service.up", 1, nil, now}},
+		},
+		{
+			name:     "escaped measurement, tags and fields",
+			output:   `my\ app,path=/a\,b\=c\ d req\ count=1` + "\n",
+			expected: []execTestMetric{{"my app.req count", 1, map[string]string{"path": "/a,b=c d"}, now}},
+		},
+		{
+			name:     "comments and blank lines",
+			output:   "# a comment\n\nqueue value=1\n",
+			expected: []execTestMetric{{"queue", 1, nil, now}},
+		},
+		{
+			name:     "missing fields",
+			output:   "queue\nqueue value=1\n",
+			expected: []execTestMetric{{"queue", 1, nil, now}},
+			invalid:  true,
+		},
+		{
+			name:     "field without a value",
+			output:   "queue depth\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+		{
+			name:     "tag without a value",
+			output:   "queue,name depth=1\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+		{
+			name:     "invalid timestamp",
+			output:   "queue depth=1 yesterday\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+		{
+			name:     "missing measurement",
+			output:   ",name=jobs depth=1\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+	}
+
+	for _, test := range tests {
+		metrics, err := parseInfluxOutput(test.output, execTestNow)
+		if (err != nil) != test.invalid {
+			t.Errorf("%s: expected invalid %v, got error %v", test.name, test.invalid, err)
+		}
+		if actual := execTestMetrics(metrics); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestParseNagiosOutput(t *testing.T) {
+	now := execTestNow.UnixNano()
+
+	tests := []struct {
+		name     string
+		output   string
+		expected []execTestMetric
+		invalid  bool
+	}{
+		{
+			name:     "no perfdata",
+			output:   "OK - all good\n",
+			expected: []execTestMetric{},
+		},
+		{
+			name:     "plain value",
+			output:   "OK - 3 users | users=3\n",
+			expected: []execTestMetric{{"users", 3, nil, now}},
+		},
+		{
+			name:   "thresholds, min and max",
+			output: "WARNING - load | load1=5.2;4;8;0;16\n",
+			expected: []execTestMetric{
+				{"load1", 5.2, nil, now},
+				{"load1.warn", 4, nil, now},
+				{"load1.crit", 8, nil, now},
+				{"load1.min", 0, nil, now},
+				{"load1.max", 16, nil, now},
+			},
+		},
+		{
+			name:   "units scale the value and thresholds",
+			output: "OK | time=250ms;500;1000 size=2KB;;;0;4 used=80%;90;95\n",
+			expected: []execTestMetric{
+				{"time", 0.25, nil, now},
+				{"time.warn", 0.5, nil, now},
+				{"time.crit", 1, nil, now},
+				{"size", 2048, nil, now},
+				{"size.min", 0, nil, now},
+				{"size.max", 4096, nil, now},
+				{"used", 80, nil, now},
+				{"used.warn", 90, nil, now},
+				{"used.crit", 95, nil, now},
+			},
+		},
+		{
+			name:   "range thresholds are skipped",
+			output: "OK | temp=20;10:30;@5:35\n",
+			expected: []execTestMetric{
+				{"temp", 20, nil, now},
+			},
+		},
+		{
+			name:   "quoted labels and counters",
+			output: "OK | 'used space'=10GB 'it''s'=1 requests=100c\n",
+			expected: []execTestMetric{
+				{"used_space", 10 * 1024 * 1024 * 1024, nil, now},
+				{"it's", 1, nil, now},
+				{"requests", 100, nil, now},
+			},
+		},
+		{
+			name:   "long output perfdata",
+			output: "OK - first | a=1\nlong output line\nmore output | b=2\nc=3\n",
+			expected: []execTestMetric{
+				{"a", 1, nil, now},
+				{"b", 2, nil, now},
+				{"c", 3, nil, now},
+			},
+		},
+		{
+			name:     "missing value",
+			output:   "OK | a= b=2\n",
+			expected: []execTestMetric{{"b", 2, nil, now}},
+			invalid:  true,
+		},
+		{
+			name:     "non numeric value",
+			output:   "OK | a=U;1;2\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+		{
+			name:     "missing label",
+			output:   "OK | =1 b\n",
+			expected: []execTestMetric{},
+			invalid:  true,
+		},
+	}
+
+	for _, test := range tests {
+		metrics, err := parseNagiosOutput(test.output, execTestNow)
+		if (err != nil) != test.invalid {
+			t.Errorf("%s: expected invalid %v, got error %v", test.name, test.invalid, err)
+		}
+		if actual := execTestMetrics(metrics); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, actual)
+		}
+	}
+}
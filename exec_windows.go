@@ -0,0 +1,15 @@
+// +build windows
+
+package main
+
+import (
+	"os/exec"
+)
+
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+func killCommand(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
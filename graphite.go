@@ -1,24 +1,104 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
 const GraphiteModuleName = "graphite"
 
+const (
+	defaultGraphiteBatchSize    = 500
+	defaultGraphiteWriteTimeout = 10
+
+	// udp writes are split to fit in a single packet
+	graphiteUDPPayloadSize = 1400
+
+	// a destination that fails isn't retried until its backoff has passed, which doubles after every
+	// failure up to the maximum
+	graphiteMinBackoff = time.Second
+	graphiteMaxBackoff = time.Minute
+)
+
+// the default metric path, e.g. sysminerd.web1_example_com.redis.cache.used_memory
+const defaultGraphiteTemplate = "sysminerd.{{.Hostname}}.{{.Module}}{{with .Instance}}.{{.}}{{end}}.{{.Name}}"
+
+// the template variables set by the module, which config tags can't be named after
+var graphiteTemplateVariables = []string{"Hostname", "ShortHostname", "ReversedHostname", "Tags", "Module", "Instance", "Name"}
+
+// characters that aren't safe in a graphite path component, and what they're replaced with
+const (
+	defaultGraphiteSanitizePattern     = `[^a-zA-Z0-9_\-]`
+	defaultGraphiteSanitizeReplacement = "_"
+)
+
+// routing modes for sending metrics to several destinations
+const (
+	GraphiteReplicate      = "replicate"
+	GraphiteFailover       = "failover"
+	GraphiteConsistentHash = "consistent-hash"
+)
+
+func init() {
+	settings := []ModuleSetting{
+		{"template", "text/template for metric paths, see the readme for the variables available"},
+		{"sanitize_pattern", "regular expression matching characters that are replaced in each path component"},
+		{"sanitize_replacement", "replacement for characters matched by sanitize_pattern, _ by default"},
+		{"hostname", "graphite host"},
+		{"port", "graphite port"},
+		{"destinations", "list of host:port or host:port:instance destinations, used instead of hostname and port"},
+		{"routing", "replicate, failover, or consistent-hash, replicate by default"},
+		{"protocol", "tcp or udp"},
+		{"format", "line or pickle, pickle requires tcp and usually uses port 2004"},
+		{"batch_size", "number of metrics sent in each write, 500 by default"},
+		{"write_timeout", "seconds before a write to graphite is abandoned, 10 by default"},
+		{"debug", "log every metric sent"},
+		{"max_queue_size", "number of unsent metrics to keep for retrying, unlimited by default"},
+		{"spool_dir", "directory to spool unsent metrics to instead of keeping them in memory"},
+		{"spool_max_size", "megabytes of spooled metrics to keep, 100 by default"},
+		{"spool_max_age", "seconds to keep spooled metrics, a day by default"},
+	}
+	RegisterModule(GraphiteModuleName, func() Module { return &GraphiteOutputModule{} },
+		append(settings, tlsSettings...)...)
+}
+
 type GraphiteOutputModule struct {
-	Prefix        string
-	Hostname      string
-	Port          int
-	Protocol      string
-	MaxQueueSize  int
-	conn          net.Conn
-	queuedMetrics []string
+	Template     *template.Template
+	Destinations []*GraphiteDestination
+	Routing      string
+	Protocol     string
+	Format       string
+	BatchSize    int
+	WriteTimeout time.Duration
+	Debug        bool
+	MaxQueueSize int
+	ring         *ConsistentHashRing
+	tlsConfig    *tls.Config
+	sanitizer    *regexp.Regexp
+	replacement  string
+	templateData map[string]interface{}
+}
+
+// GraphiteDestination is a single carbon server.  Each destination has its own connection and queue
+// of unsent metrics, so one that is down doesn't hold up the others.
+type GraphiteDestination struct {
+	Hostname string
+	Port     int
+	Instance string
+	conn     net.Conn
+	queue    *MetricQueue
+	backoff  time.Duration
+	retryAt  time.Time
 }
 
 func (m *GraphiteOutputModule) Name() string {
@@ -26,39 +106,69 @@ func (m *GraphiteOutputModule) Name() string {
 }
 
 func (m *GraphiteOutputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
-	var hostname string
-	var err error
+	// parse the metric path template
+	templateSetting, err := moduleConfig.SettingsString("template")
+	if err != nil || templateSetting == "" {
+		templateSetting = defaultGraphiteTemplate
+	}
 
-	if config.Hostname != "" {
-		hostname = config.Hostname
-	} else {
-		hostname, err = os.Hostname()
-		if err != nil {
-			addrs, err := net.InterfaceAddrs()
-			if err != nil || len(addrs) == 0 {
-				log.Printf("Unable to get the system hostname: %v", err)
-				hostname = "unknown"
-			} else {
-				hostname = addrs[0].String()
-			}
-		}
+	graphiteTemplate, err := template.New("graphite").Option("missingkey=error").Funcs(template.FuncMap{
+		"env": os.Getenv,
+	}).Parse(templateSetting)
+	if err != nil {
+		log.Fatalf("Unable to parse graphite template: %v", err)
 	}
 
-	// replace periods in the fqdn with underscores
-	hostname = strings.Replace(hostname, ".", "_", -1)
-	graphitePrefix := fmt.Sprintf("sysminerd.%s", hostname)
+	sanitizePattern, err := moduleConfig.SettingsString("sanitize_pattern")
+	if err != nil || sanitizePattern == "" {
+		sanitizePattern = defaultGraphiteSanitizePattern
+	}
 
-	// parse graphite settings
-	graphiteHostname, err := moduleConfig.SettingsString("hostname")
-	if err != nil || graphiteHostname == "" {
-		log.Fatalf("hostname must be specified: %v", err)
+	sanitizer, err := regexp.Compile(sanitizePattern)
+	if err != nil {
+		log.Fatalf("Unable to parse graphite sanitize_pattern: %v", err)
 	}
 
-	graphitePort, err := moduleConfig.SettingsInt("port")
+	replacement, err := moduleConfig.SettingsString("sanitize_replacement")
 	if err != nil {
-		log.Fatalf("Unable to parse port: %v", err)
-	} else if graphitePort < 1 || graphitePort > 65535 {
-		log.Fatalf("invalid port number: %d", graphitePort)
+		replacement = defaultGraphiteSanitizeReplacement
+	}
+
+	// parse graphite settings, a single destination can be given as hostname and port
+	destinationSettings, err := moduleConfig.SettingsStringArray("destinations")
+	if err != nil || len(destinationSettings) == 0 {
+		graphiteHostname, err := moduleConfig.SettingsString("hostname")
+		if err != nil || graphiteHostname == "" {
+			log.Fatalf("hostname must be specified: %v", err)
+		}
+
+		graphitePort, err := moduleConfig.SettingsInt("port")
+		if err != nil {
+			log.Fatalf("Unable to parse port: %v", err)
+		}
+
+		destinationSettings = []string{fmt.Sprintf("%s:%d", graphiteHostname, graphitePort)}
+	}
+
+	destinations := make([]*GraphiteDestination, 0, len(destinationSettings))
+	hosts := make([]string, 0, len(destinationSettings))
+	instances := make([]string, 0, len(destinationSettings))
+	for _, setting := range destinationSettings {
+		destination, err := parseGraphiteDestination(setting)
+		if err != nil {
+			log.Fatalf("Invalid graphite destination %s: %v", setting, err)
+		}
+		destinations = append(destinations, destination)
+		hosts = append(hosts, destination.Hostname)
+		instances = append(instances, destination.Instance)
+	}
+
+	routing, err := moduleConfig.SettingsString("routing")
+	if err != nil || routing == "" {
+		routing = GraphiteReplicate
+	}
+	if routing != GraphiteReplicate && routing != GraphiteFailover && routing != GraphiteConsistentHash {
+		log.Fatalf("Graphite routing %s is not supported", routing)
 	}
 
 	protocol, err := moduleConfig.SettingsString("protocol")
@@ -66,93 +176,446 @@ func (m *GraphiteOutputModule) Init(config *Config, moduleConfig *ModuleConfig)
 		log.Fatalf("Graphite protocol %s is not supported", protocol)
 	}
 
-	maxQueueSize, err := moduleConfig.SettingsInt("max_queue_size")
+	format, err := moduleConfig.SettingsString("format")
+	if err != nil || format == "" {
+		format = "line"
+	}
+	if format != "line" && format != "pickle" {
+		log.Fatalf("Graphite format %s is not supported", format)
+	}
+	if format == "pickle" && protocol != "tcp" {
+		log.Fatalf("The graphite pickle format requires the tcp protocol")
+	}
+
+	batchSize, err := moduleConfig.SettingsInt("batch_size")
+	if err != nil || batchSize < 1 {
+		batchSize = defaultGraphiteBatchSize
+	}
+
+	writeTimeout, err := moduleConfig.SettingsFloat("write_timeout")
+	if err != nil || writeTimeout <= 0 {
+		writeTimeout = defaultGraphiteWriteTimeout
+	}
+
+	debug, _ := moduleConfig.SettingsBool("debug")
+
+	tlsConfig, err := newTLSConfig(moduleConfig)
+	if err != nil {
+		log.Fatalf("Unable to configure graphite tls: %v", err)
+	}
+	if tlsConfig != nil && protocol != "tcp" {
+		log.Fatalf("Graphite tls requires the tcp protocol")
+	}
+
+	err = validateGraphiteTags(config.Tags)
 	if err != nil {
-		maxQueueSize = 0
+		log.Fatalf("Unable to use the tags with graphite: %v", err)
 	}
 
 	// save config data
-	m.Prefix = graphitePrefix
-	m.Hostname = graphiteHostname
-	m.Port = int(graphitePort)
+	m.Template = graphiteTemplate
+	m.sanitizer = sanitizer
+	m.replacement = replacement
+	m.templateData = m.newTemplateData(config)
+	m.Destinations = destinations
+	m.Routing = routing
 	m.Protocol = protocol
-	m.MaxQueueSize = int(maxQueueSize)
+	m.Format = format
+	m.BatchSize = batchSize
+	m.WriteTimeout = secondsToDuration(writeTimeout)
+	m.Debug = debug
+	m.tlsConfig = tlsConfig
+	// check the template can be rendered before any metrics are sent
+	_, err = m.metricPath(&ModuleMetrics{Module: GraphiteModuleName}, NewMetric("test", 0))
+	if err != nil {
+		log.Fatalf("Unable to render graphite template: %v", err)
+	}
 
-	// connect to graphite
-	m.conn, err = connectToGraphite(m.Hostname, m.Port, m.Protocol)
+	if routing == GraphiteConsistentHash {
+		m.ring = NewConsistentHashRing(hosts, instances)
+	}
 
-	return err
-}
+	for _, destination := range m.Destinations {
+		// a single destination spools to the module directory, several get a directory each
+		spoolDir := ""
+		if len(m.Destinations) > 1 {
+			spoolDir = strings.Replace(destination.String(), ":", "_", -1)
+		}
+		destination.queue = newOutputQueue(fmt.Sprintf("Graphite %s", destination), moduleConfig, spoolDir)
+		m.MaxQueueSize = destination.queue.MaxSize
 
-func (m *GraphiteOutputModule) TearDown() error {
-	if m.conn != nil {
-		return m.conn.Close()
+		// connect to graphite, a destination that isn't up yet is retried after its backoff
+		destination.conn, err = connectToGraphite(destination.Hostname, destination.Port, m.Protocol, m.tlsConfig)
+		if err != nil {
+			destination.failed()
+		}
 	}
+
 	return nil
 }
 
-func (m *GraphiteOutputModule) SendMetrics(moduleMetrics []*ModuleMetrics) error {
+func (m *GraphiteOutputModule) TearDown() error {
 	var err error
+	for _, destination := range m.Destinations {
+		if destination.conn != nil {
+			err = destination.conn.Close()
+			destination.conn = nil
+		}
+	}
+	return err
+}
 
+func (m *GraphiteOutputModule) SendMetrics(moduleMetrics []*ModuleMetrics) error {
 	metrics := make([]string, 0, len(moduleMetrics)*5)
 
 	// convert metrics to graphite metrics
 	for _, module := range moduleMetrics {
-		moduleName := module.Module
 		for _, metric := range module.Metrics {
-			metricName := fmt.Sprintf("%s.%s.%s", m.Prefix, moduleName, metric.Name)
+			metricName, err := m.metricPath(module, metric)
+			if err != nil {
+				log.Printf("Unable to render graphite path for %s: %v", metric.Name, err)
+				continue
+			}
 			graphiteMetric := fmt.Sprintf("%s %f %d\n", metricName, metric.Value, metric.Timestamp.Unix())
 			metrics = append(metrics, graphiteMetric)
 		}
 	}
 
-	// add queued metrics also
-	if len(m.queuedMetrics) > 0 {
-		metrics = append(m.queuedMetrics, metrics...)
-		m.queuedMetrics = make([]string, 0, len(metrics))
+	// add the metrics behind any that are already queued
+	m.route(metrics)
+
+	now := time.Now()
+	var err error
+	for _, destination := range m.Destinations {
+		if destination.queue.Len() == 0 {
+			continue
+		}
+
+		// wait out the backoff of a failed destination, nothing is sent so its queue is committed
+		// without any metrics to spool or trim what it's holding
+		if !destination.available(now) {
+			destination.queue.Commit(0)
+			err = fmt.Errorf("graphite destination %s is unavailable", destination)
+			continue
+		}
+
+		sendErr := m.sendQueued(destination, time.Time{})
+		if sendErr != nil {
+			err = sendErr
+		}
+	}
+
+	return err
+}
+
+// newTemplateData returns the template variables that are the same for every metric.  Config tags
+// are available both as .Tags and as a variable of their own named after the tag, so the env tag is
+// .Env and a data_center tag is .DataCenter.
+func (m *GraphiteOutputModule) newTemplateData(config *Config) map[string]interface{} {
+	fqdn := getHostname(config)
+
+	hostParts := strings.Split(fqdn, ".")
+	reversed := make([]string, 0, len(hostParts))
+	for i := len(hostParts) - 1; i >= 0; i-- {
+		reversed = append(reversed, m.sanitize(hostParts[i]))
 	}
 
-	// attempt to reconnect to graphite
-	if m.conn == nil {
-		graphiteConnection, err := connectToGraphite(m.Hostname, m.Port, m.Protocol)
-		if err == nil {
-			log.Print("Reconnected to graphite")
-			m.conn = graphiteConnection
+	tags := make(map[string]string, len(config.Tags))
+	data := make(map[string]interface{}, len(config.Tags)+8)
+	for key, value := range config.Tags {
+		tags[key] = m.sanitize(value)
+		data[templateVariableName(key)] = tags[key]
+	}
+
+	// periods in the fqdn are replaced with underscores so it's a single component
+	data["Hostname"] = m.sanitize(fqdn)
+	data["ShortHostname"] = m.sanitize(hostParts[0])
+	data["ReversedHostname"] = strings.Join(reversed, ".")
+	data["Tags"] = tags
+
+	return data
+}
+
+// metricPath renders the graphite path of a metric.  The metric name is the metric tag values in tag
+// key order followed by its name, e.g. cpu0.user, and every component is sanitized.
+func (m *GraphiteOutputModule) metricPath(module *ModuleMetrics, metric Metric) (string, error) {
+	parts := make([]string, 0, len(metric.Tags)+1)
+	for _, key := range metric.TagKeys() {
+		parts = append(parts, m.sanitize(metric.Tags[key]))
+	}
+	for _, part := range strings.Split(metric.Name, ".") {
+		parts = append(parts, m.sanitize(part))
+	}
+
+	m.templateData["Module"] = m.sanitize(module.Module)
+	m.templateData["Instance"] = m.sanitize(module.Instance)
+	m.templateData["Name"] = strings.Join(parts, ".")
+
+	var path bytes.Buffer
+	err := m.Template.Execute(&path, m.templateData)
+	if err != nil {
+		return "", err
+	}
+
+	return path.String(), nil
+}
+
+// sanitize replaces the characters in a path component that graphite can't store, periods included
+// since they separate components
+func (m *GraphiteOutputModule) sanitize(component string) string {
+	component = strings.Replace(component, ".", m.replacement, -1)
+	return m.sanitizer.ReplaceAllLiteralString(component, m.replacement)
+}
+
+// route queues metrics for the destinations chosen by the routing mode.  Replicate queues every metric
+// for every destination, failover queues them for the first available destination, along with
+// whatever the unavailable destinations have queued, and consistent-hash queues each metric for the
+// destination carbon-relay would pick from its name.
+func (m *GraphiteOutputModule) route(metrics []string) {
+	switch m.Routing {
+	case GraphiteFailover:
+		now := time.Now()
+		var active *GraphiteDestination
+		for _, destination := range m.Destinations {
+			if destination.available(now) {
+				active = destination
+				break
+			}
+		}
+		// all of them are down, wait for the primary
+		if active == nil {
+			m.Destinations[0].queue.Push(metrics)
+			return
+		}
+
+		for _, destination := range m.Destinations {
+			if destination == active || destination.available(now) || destination.queue.Len() == 0 {
+				continue
+			}
+			moved := destination.queue.Drain()
+			log.Printf("Moving %d queued graphite metrics from %s to %s", len(moved), destination, active)
+			active.queue.Push(moved)
+		}
+		active.queue.Push(metrics)
+	case GraphiteConsistentHash:
+		routed := make([][]string, len(m.Destinations))
+		for _, metric := range metrics {
+			name := metric
+			if i := strings.IndexByte(metric, ' '); i >= 0 {
+				name = metric[:i]
+			}
+			node := m.ring.Node(name)
+			routed[node] = append(routed[node], metric)
+		}
+		for i, destination := range m.Destinations {
+			destination.queue.Push(routed[i])
+		}
+	default:
+		for _, destination := range m.Destinations {
+			destination.queue.Push(metrics)
 		}
 	}
+}
 
-	// for now just print the metrics
-	for _, metric := range metrics {
-		if m.conn == nil {
-			m.queuedMetrics = append(m.queuedMetrics, metric)
+// Flush makes a last attempt to send any queued metrics before the deadline, including to
+// destinations that are backing off
+func (m *GraphiteOutputModule) Flush(deadline time.Time) error {
+	var err error
+	for _, destination := range m.Destinations {
+		if destination.queue.Len() == 0 {
 			continue
 		}
 
-		log.Printf("Graphite: %s", metric)
+		log.Printf("Flushing %d queued graphite metrics to %s", destination.queue.Len(), destination)
 
-		n, err := m.conn.Write([]byte(metric))
-		if err != nil || n != len(metric) {
-			log.Printf("Error sending graphite metric: %v", err)
-			m.queuedMetrics = append(m.queuedMetrics, metric)
+		sendErr := m.sendQueued(destination, deadline)
+		if sendErr != nil {
+			err = sendErr
+		}
+	}
+
+	return err
+}
+
+// sendQueued sends the queued metrics of a destination in batches until a write fails, anything
+// unsent stays queued.  Each write times out after the write timeout, or at the deadline if it isn't
+// zero and comes first.
+func (m *GraphiteOutputModule) sendQueued(destination *GraphiteDestination, deadline time.Time) error {
+	var err error
+
+	// attempt to reconnect to graphite
+	if destination.conn == nil {
+		graphiteConnection, err := connectToGraphite(destination.Hostname, destination.Port, m.Protocol, m.tlsConfig)
+		if err != nil {
+			destination.failed()
+			destination.queue.Commit(0)
+			return err
+		}
+		log.Printf("Reconnected to graphite %s", destination)
+		destination.conn = graphiteConnection
+	}
+
+	// spooled metrics are sent a batch at a time
+	for destination.conn != nil && destination.queue.Len() > 0 {
+		queued := destination.queue.Peek()
+		if len(queued) == 0 {
+			break
+		}
 
-			// close the existing connection
-			m.conn.Close()
-			m.conn = nil
+		sent := 0
+		for sent < len(queued) {
+			end := m.batchEnd(queued, sent)
+
+			err = m.write(destination.conn, queued[sent:end], deadline)
+			if err != nil {
+				log.Printf("Error sending graphite metrics to %s: %v", destination, err)
+
+				// close the existing connection
+				destination.failed()
+				break
+			}
+			sent = end
 		}
+		destination.queue.Commit(sent)
 	}
 
-	//see if we need to trim the queued metrics
-	if m.MaxQueueSize > 0 && len(m.queuedMetrics) > m.MaxQueueSize {
-		log.Printf("Graphite metric queue overflow, throwing away %d metrics", len(m.queuedMetrics)-m.MaxQueueSize)
-		m.queuedMetrics = m.queuedMetrics[len(m.queuedMetrics)-m.MaxQueueSize:]
+	if err == nil {
+		destination.backoff = 0
 	}
 
 	return err
 }
 
-func connectToGraphite(hostname string, port int, protocol string) (net.Conn, error) {
+// batchEnd returns the end of the batch starting at start.  A batch holds up to BatchSize metrics,
+// and for udp no more than fits in a packet.
+func (m *GraphiteOutputModule) batchEnd(lines []string, start int) int {
+	end := start + m.BatchSize
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	if m.Protocol == "udp" {
+		size := len(lines[start])
+		for i := start + 1; i < end; i++ {
+			size += len(lines[i])
+			if size > graphiteUDPPayloadSize {
+				return i
+			}
+		}
+	}
+
+	return end
+}
+
+// write sends a batch of metrics in a single write
+func (m *GraphiteOutputModule) write(conn net.Conn, lines []string, deadline time.Time) error {
+	writeDeadline := time.Now().Add(m.WriteTimeout)
+	if !deadline.IsZero() && deadline.Before(writeDeadline) {
+		writeDeadline = deadline
+	}
+	conn.SetWriteDeadline(writeDeadline)
+
+	if m.Debug {
+		for _, line := range lines {
+			log.Printf("Graphite: %s", strings.TrimSpace(line))
+		}
+	}
+
+	var data []byte
+	if m.Format == "pickle" {
+		data = graphitePickle(lines)
+	} else {
+		data = []byte(strings.Join(lines, ""))
+	}
+
+	_, err := conn.Write(data)
+
+	return err
+}
+
+func (d *GraphiteDestination) String() string {
+	if d.Instance != "" {
+		return fmt.Sprintf("%s:%d:%s", d.Hostname, d.Port, d.Instance)
+	}
+	return fmt.Sprintf("%s:%d", d.Hostname, d.Port)
+}
+
+// available returns whether metrics can be sent to the destination, which is when it's connected or
+// its backoff has passed
+func (d *GraphiteDestination) available(now time.Time) bool {
+	return d.conn != nil || !now.Before(d.retryAt)
+}
+
+// failed closes the connection to the destination and backs off before it's retried
+func (d *GraphiteDestination) failed() {
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+
+	d.backoff *= 2
+	if d.backoff < graphiteMinBackoff {
+		d.backoff = graphiteMinBackoff
+	} else if d.backoff > graphiteMaxBackoff {
+		d.backoff = graphiteMaxBackoff
+	}
+	d.retryAt = time.Now().Add(d.backoff)
+}
+
+// parseGraphiteDestination parses a destination in carbon's host:port:instance format, where the
+// instance is optional.  The instance only affects consistent hashing.
+func parseGraphiteDestination(setting string) (*GraphiteDestination, error) {
+	fields := strings.Split(setting, ":")
+	if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+		return nil, errors.New("destinations must be host:port or host:port:instance")
+	}
+
+	port, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid port number: %d", port)
+	}
+
+	destination := &GraphiteDestination{Hostname: fields[0], Port: port}
+	if len(fields) == 3 {
+		destination.Instance = fields[2]
+	}
+
+	return destination, nil
+}
+
+// validateGraphiteTags checks that no config tag would replace a built in template variable, since
+// each tag becomes a variable of its own
+func validateGraphiteTags(tags map[string]string) error {
+	reserved := StringSet{}
+	reserved.AddAll(graphiteTemplateVariables)
+
+	for _, key := range sortedTagKeys(tags) {
+		name := templateVariableName(key)
+		if reserved.Contains(name) {
+			return fmt.Errorf("the %s tag collides with the .%s template variable", key, name)
+		}
+	}
+	return nil
+}
+
+// templateVariableName title cases a tag key to use it as a template variable, e.g. data_center
+// becomes DataCenter
+func templateVariableName(key string) string {
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	})
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, "")
+}
+
+func connectToGraphite(hostname string, port int, protocol string, tlsConfig *tls.Config) (net.Conn, error) {
 	address := fmt.Sprintf("%s:%d", hostname, port)
-	conn, err := net.DialTimeout(protocol, address, 5*time.Second)
+	conn, err := dialTimeout(protocol, address, 5*time.Second, tlsConfig)
 	if err != nil {
 		log.Printf("Failed to connect to graphite: %v", err)
 	}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGraphiteFailoverMovesQueuedMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphite-failover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := OpenSpool(dir, 1024*1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := &GraphiteDestination{Hostname: "primary", Port: 2003, queue: NewSpooledMetricQueue("primary", 0, spool)}
+	secondary := &GraphiteDestination{Hostname: "secondary", Port: 2003, queue: NewMetricQueue("secondary", 0)}
+	m := &GraphiteOutputModule{Routing: GraphiteFailover, Destinations: []*GraphiteDestination{primary, secondary}}
+
+	// the primary is up, so it gets the first metrics
+	m.route([]string{"a 1 1\n"})
+	if primary.queue.Len() != 1 || secondary.queue.Len() != 0 {
+		t.Fatalf("expected the primary to queue the metrics, got %d and %d", primary.queue.Len(), secondary.queue.Len())
+	}
+
+	// the primary fails with one metric spooled and one in memory
+	primary.queue.Commit(0)
+	primary.queue.Push([]string{"b 2 2\n"})
+	primary.failed()
+
+	m.route([]string{"c 3 3\n"})
+
+	if primary.queue.Len() != 0 {
+		t.Errorf("expected the failed primary's queue to be moved, %d metrics are left", primary.queue.Len())
+	}
+	expected := []string{"a 1 1\n", "b 2 2\n", "c 3 3\n"}
+	if queued := secondary.queue.Peek(); !reflect.DeepEqual(queued, expected) {
+		t.Errorf("expected the secondary to queue %q, got %q", expected, queued)
+	}
+
+	// with every destination down the primary waits for the metrics
+	secondary.failed()
+	m.route([]string{"d 4 4\n"})
+	if primary.queue.Len() != 1 || secondary.queue.Len() != 3 {
+		t.Errorf("expected the primary to queue the metrics while everything is down, got %d and %d",
+			primary.queue.Len(), secondary.queue.Len())
+	}
+}
+
+func TestValidateGraphiteTags(t *testing.T) {
+	tests := []struct {
+		tags  map[string]string
+		valid bool
+	}{
+		{nil, true},
+		{map[string]string{"env": "prod", "data_center": "east", "host": "web1"}, true},
+		{map[string]string{"hostname": "web1"}, false},
+		{map[string]string{"short-hostname": "web1"}, false},
+		{map[string]string{"reversed_hostname": "web1"}, false},
+		{map[string]string{"module": "cpu"}, false},
+		{map[string]string{"instance": "cache"}, false},
+		{map[string]string{"name": "user"}, false},
+		{map[string]string{"tags": "a"}, false},
+	}
+
+	for _, test := range tests {
+		err := validateGraphiteTags(test.tags)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: expected valid %v, got error %v", test.tags, test.valid, err)
+		}
+	}
+}
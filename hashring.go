@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const hashRingReplicas = 100
+
+// ConsistentHashRing maps keys to nodes the same way as carbon's ConsistentHashRing with the default
+// carbon_ch hash, so metrics are routed to the same carbon-cache that carbon-relay would pick.  Nodes
+// are identified the way carbon-relay identifies destinations, by their host and instance.
+type ConsistentHashRing struct {
+	ring []hashRingEntry
+}
+
+type hashRingEntry struct {
+	position int
+	node     int
+}
+
+// NewConsistentHashRing creates a ring of the destinations.  Nodes are returned as their index in
+// hosts and instances, which must be the same length, and an empty instance is carbon's None.
+func NewConsistentHashRing(hosts []string, instances []string) *ConsistentHashRing {
+	ring := &ConsistentHashRing{}
+	used := make(map[int]bool)
+
+	for node := range hosts {
+		key := hashRingNodeKey(hosts[node], instances[node])
+
+		for i := 0; i < hashRingReplicas; i++ {
+			position := hashRingPosition(fmt.Sprintf("%s:%d", key, i))
+
+			// carbon moves colliding replicas along to the next free position
+			for used[position] {
+				position++
+			}
+			used[position] = true
+
+			ring.ring = append(ring.ring, hashRingEntry{position: position, node: node})
+		}
+	}
+
+	sort.Sort(hashRingByPosition(ring.ring))
+
+	return ring
+}
+
+// Node returns the node for a key, which is the first node on the ring at or after the key's position
+func (r *ConsistentHashRing) Node(key string) int {
+	position := hashRingPosition(key)
+
+	i := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i].position >= position
+	})
+
+	return r.ring[i%len(r.ring)].node
+}
+
+// hashRingPosition is the first 4 hex digits of the md5 of the key
+func hashRingPosition(key string) int {
+	sum := md5.Sum([]byte(key))
+	return int(binary.BigEndian.Uint16(sum[:2]))
+}
+
+// hashRingNodeKey formats the node the way python formats carbon's (server, instance) tuple
+func hashRingNodeKey(host string, instance string) string {
+	if instance == "" {
+		return fmt.Sprintf("('%s', None)", host)
+	}
+	return fmt.Sprintf("('%s', '%s')", host, instance)
+}
+
+type hashRingByPosition []hashRingEntry
+
+func (r hashRingByPosition) Len() int           { return len(r) }
+func (r hashRingByPosition) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r hashRingByPosition) Less(i, j int) bool { return r[i].position < r[j].position }
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+// the expected nodes were computed with carbon's ConsistentHashRing, so a metric here goes to the
+// same carbon-cache that carbon-relay would send it to
+func TestConsistentHashRing(t *testing.T) {
+	tests := []struct {
+		name      string
+		hosts     []string
+		instances []string
+		nodes     map[string]int
+	}{
+		{
+			name:      "without instances",
+			hosts:     []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			instances: []string{"", "", ""},
+			nodes: map[string]int{
+				"servers.web1.cpu.user":               2,
+				"servers.web2.cpu.user":               2,
+				"servers.db1.memory.free":             0,
+				"carbon.agents.relay.metricsReceived": 0,
+				"stats.counters.requests":             1,
+				"a":                                   1,
+			},
+		},
+		{
+			name:      "with instances",
+			hosts:     []string{"127.0.0.1", "127.0.0.1", "127.0.0.1"},
+			instances: []string{"a", "b", "c"},
+			nodes: map[string]int{
+				"servers.web1.cpu.user":               2,
+				"servers.web2.cpu.user":               0,
+				"servers.db1.memory.free":             1,
+				"carbon.agents.relay.metricsReceived": 1,
+				"stats.counters.requests":             2,
+				"a":                                   0,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		ring := NewConsistentHashRing(test.hosts, test.instances)
+		if len(ring.ring) != len(test.hosts)*hashRingReplicas {
+			t.Errorf("%s: got %d replicas, want %d", test.name, len(ring.ring), len(test.hosts)*hashRingReplicas)
+		}
+
+		for key, want := range test.nodes {
+			if node := ring.Node(key); node != want {
+				t.Errorf("%s: Node(%q) = %d, want %d", test.name, key, node, want)
+			}
+		}
+	}
+}
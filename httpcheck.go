@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const HTTPCheckModuleName = "http_check"
+
+func init() {
+	RegisterModule(HTTPCheckModuleName, func() Module { return &HTTPCheckInputModule{} },
+		ModuleSetting{"timeout", "default seconds a check may take, defaults to 5"},
+		ModuleSetting{"checks", "list of checks, each with a name, url, and optional method, status_codes, body_regex, headers, timeout, follow_redirects, and tls settings"},
+	)
+}
+
+const defaultHTTPCheckTimeout = 5 * time.Second
+
+// only the start of a response body is matched against body_regex
+const httpCheckMaxBody = 1024 * 1024
+
+// HTTPCheck is a url probed by the http_check module
+type HTTPCheck struct {
+	Name            string
+	URL             string
+	Method          string
+	StatusCodes     []int
+	BodyRegex       *regexp.Regexp
+	Headers         map[string]string
+	Timeout         time.Duration
+	FollowRedirects bool
+	client          *http.Client
+}
+
+type HTTPCheckInputModule struct {
+	Checks []*HTTPCheck
+}
+
+func (m *HTTPCheckInputModule) Name() string {
+	return HTTPCheckModuleName
+}
+
+func (m *HTTPCheckInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	defaultTimeout := defaultHTTPCheckTimeout
+	timeout, err := moduleConfig.SettingsFloat("timeout")
+	if err == nil && timeout > 0 {
+		defaultTimeout = secondsToDuration(timeout)
+	}
+
+	checkConfigs, err := moduleConfig.SettingsMapArray("checks")
+	if err != nil {
+		log.Fatalf("checks must be specified: %v", err)
+	}
+
+	names := StringSet{}
+	m.Checks = make([]*HTTPCheck, 0, len(checkConfigs))
+
+	for _, checkConfig := range checkConfigs {
+		check := &HTTPCheck{Timeout: defaultTimeout, FollowRedirects: true}
+
+		check.Name, err = checkConfig.SettingsString("name")
+		if err != nil || check.Name == "" {
+			log.Fatalf("http check name must be specified: %v", err)
+		}
+		if names.Contains(check.Name) {
+			log.Fatalf("http check %s is specified more than once", check.Name)
+		}
+		names.Add(check.Name)
+
+		check.URL, err = checkConfig.SettingsString("url")
+		if err != nil || check.URL == "" {
+			log.Fatalf("http check url must be specified for %s: %v", check.Name, err)
+		}
+
+		check.Method, err = checkConfig.SettingsString("method")
+		if err != nil || check.Method == "" {
+			check.Method = "GET"
+		}
+		check.Method = strings.ToUpper(check.Method)
+
+		statusCodes, err := checkConfig.SettingsArray("status_codes")
+		if err == nil {
+			for _, code := range statusCodes {
+				icode, ok := code.(int)
+				if !ok {
+					log.Fatalf("http check status_codes must be numbers for %s", check.Name)
+				}
+				check.StatusCodes = append(check.StatusCodes, icode)
+			}
+		}
+
+		bodyRegex, err := checkConfig.SettingsString("body_regex")
+		if err == nil && bodyRegex != "" {
+			check.BodyRegex, err = regexp.Compile(bodyRegex)
+			if err != nil {
+				log.Fatalf("Unable to parse body_regex for %s: %v", check.Name, err)
+			}
+		}
+
+		check.Headers, _ = checkConfig.SettingsStringMap("headers")
+
+		timeout, err := checkConfig.SettingsFloat("timeout")
+		if err == nil && timeout > 0 {
+			check.Timeout = secondsToDuration(timeout)
+		}
+
+		followRedirects, err := checkConfig.SettingsBool("follow_redirects")
+		if err == nil {
+			check.FollowRedirects = followRedirects
+		}
+
+		tlsConfig, err := newTLSConfig(&checkConfig)
+		if err != nil {
+			log.Fatalf("Unable to configure tls for %s: %v", check.Name, err)
+		}
+
+		check.client = newHTTPCheckClient(check, tlsConfig)
+
+		m.Checks = append(m.Checks, check)
+	}
+
+	return nil
+}
+
+func (m *HTTPCheckInputModule) TearDown() error {
+	return nil
+}
+
+// GetMetrics runs every check at the same time, and waits for them to finish or time out
+func (m *HTTPCheckInputModule) GetMetrics() (*ModuleMetrics, error) {
+	var wg sync.WaitGroup
+	results := make([][]Metric, len(m.Checks))
+
+	for i, check := range m.Checks {
+		wg.Add(1)
+		go func(i int, check *HTTPCheck) {
+			defer wg.Done()
+			results[i] = check.Run()
+		}(i, check)
+	}
+
+	wg.Wait()
+
+	metrics := make([]Metric, 0, len(m.Checks)*8)
+	for _, result := range results {
+		metrics = append(metrics, result...)
+	}
+
+	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+}
+
+// Run makes the request and returns the check metrics tagged with the check name.  up is 1 when the
+// response has an expected status code and, if there is a body_regex, the body matches.  The phase
+// timings are only reported for the phases the request reached.
+func (c *HTTPCheck) Run() []Metric {
+	tags := map[string]string{"check": c.Name}
+	up := 0.0
+
+	var dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { connectDone = time.Now() },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsDone = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+
+	metrics := make([]Metric, 0, 10)
+
+	start := time.Now()
+	response, err := c.do(trace)
+	if err != nil {
+		log.Printf("http check %s failed: %v", c.Name, err)
+	} else {
+		metrics = append(metrics, NewTaggedMetric("status_code", float64(response.StatusCode), tags))
+
+		body, err := ioutil.ReadAll(io.LimitReader(response.Body, httpCheckMaxBody))
+		response.Body.Close()
+
+		up = 1
+		if !c.expectedStatus(response.StatusCode) {
+			log.Printf("http check %s returned %s", c.Name, response.Status)
+			up = 0
+		} else if c.BodyRegex != nil && (err != nil || !c.BodyRegex.Match(body)) {
+			log.Printf("http check %s body doesn't match %s", c.Name, c.BodyRegex)
+			up = 0
+		}
+
+		if response.TLS != nil && len(response.TLS.PeerCertificates) > 0 {
+			expiry := response.TLS.PeerCertificates[0].NotAfter
+			metrics = append(metrics, NewTaggedMetric("cert_expiry_days", time.Until(expiry).Hours()/24, tags))
+		}
+	}
+	duration := time.Since(start)
+
+	metrics = append(metrics, NewTaggedMetric("up", up, tags))
+	metrics = append(metrics, NewTaggedMetric("duration", duration.Seconds(), tags))
+
+	phases := []struct {
+		name  string
+		start time.Time
+		end   time.Time
+	}{
+		{"dns", dnsStart, dnsDone},
+		{"connect", connectStart, connectDone},
+		{"tls", tlsStart, tlsDone},
+		{"first_byte", start, firstByte},
+	}
+	for _, phase := range phases {
+		if phase.start.IsZero() || phase.end.IsZero() {
+			continue
+		}
+		metrics = append(metrics, NewTaggedMetric(phase.name+"_duration", phase.end.Sub(phase.start).Seconds(), tags))
+	}
+
+	return metrics
+}
+
+func (c *HTTPCheck) do(trace *httptrace.ClientTrace) (*http.Response, error) {
+	request, err := http.NewRequest(c.Method, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range c.Headers {
+		if strings.EqualFold(key, "host") {
+			request.Host = value
+		} else {
+			request.Header.Set(key, value)
+		}
+	}
+
+	request = request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+
+	return c.client.Do(request)
+}
+
+// expectedStatus returns whether the status code is one of the check's status codes, or any 2xx or
+// 3xx status if none are configured
+func (c *HTTPCheck) expectedStatus(code int) bool {
+	if len(c.StatusCodes) == 0 {
+		return code >= 200 && code < 400
+	}
+
+	for _, expected := range c.StatusCodes {
+		if code == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// newHTTPCheckClient creates the client for a check.  Connections aren't reused so every request
+// goes through each phase and is timed the same way.
+func newHTTPCheckClient(check *HTTPCheck, tlsConfig *tls.Config) *http.Client {
+	transport := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+		TLSClientConfig:   tlsConfig,
+	}
+
+	client := &http.Client{Transport: transport, Timeout: check.Timeout}
+	if !check.FollowRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	return client
+}
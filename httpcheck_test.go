@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestHTTPCheckInit(t *testing.T) {
+	settings := make(map[string]interface{})
+	err := yaml.Unmarshal([]byte(`
+timeout: 2
+checks:
+  - name: api
+    url: http://localhost/health
+    method: head
+    status_codes: [200, 204]
+    body_regex: "^ok"
+    timeout: 0.5
+  - name: site
+    url: http://localhost/
+    follow_redirects: false
+`), &settings)
+	if err != nil {
+		t.Fatalf("unable to parse the settings: %v", err)
+	}
+
+	m := &HTTPCheckInputModule{}
+	m.Init(&Config{}, &ModuleConfig{Name: HTTPCheckModuleName, Settings: settings})
+
+	if len(m.Checks) != 2 {
+		t.Fatalf("got %d checks, want 2", len(m.Checks))
+	}
+
+	api := m.Checks[0]
+	if api.Method != "HEAD" {
+		t.Errorf("api method = %s, want HEAD", api.Method)
+	}
+	if !reflect.DeepEqual(api.StatusCodes, []int{200, 204}) {
+		t.Errorf("api status codes = %v, want [200 204]", api.StatusCodes)
+	}
+	if api.BodyRegex == nil || api.BodyRegex.String() != "^ok" {
+		t.Errorf("api body regex = %v, want ^ok", api.BodyRegex)
+	}
+	if api.Timeout != 500*time.Millisecond || !api.FollowRedirects {
+		t.Errorf("api timeout = %v and follow redirects = %v, want 500ms and true", api.Timeout, api.FollowRedirects)
+	}
+
+	site := m.Checks[1]
+	if site.Method != "GET" || site.StatusCodes != nil || site.BodyRegex != nil {
+		t.Errorf("site = %s %v %v, want the GET defaults", site.Method, site.StatusCodes, site.BodyRegex)
+	}
+	if site.Timeout != 2*time.Second || site.FollowRedirects {
+		t.Errorf("site timeout = %v and follow redirects = %v, want 2s and false", site.Timeout, site.FollowRedirects)
+	}
+}
+
+func TestHTTPCheckRun(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "status: ok")
+	})
+	mux.HandleFunc("/degraded", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "status: degraded")
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "status: ok")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	closed := httptest.NewServer(mux)
+	closed.Close()
+
+	okRegex := regexp.MustCompile("status: ok")
+
+	tests := []struct {
+		name            string
+		url             string
+		statusCodes     []int
+		bodyRegex       *regexp.Regexp
+		followRedirects bool
+		statusCode      float64
+		up              float64
+	}{
+		{"2xx is up by default", server.URL + "/ok", nil, nil, true, 200, 1},
+		{"4xx is down by default", server.URL + "/missing", nil, nil, true, 404, 0},
+		{"5xx is down by default", server.URL + "/error", nil, nil, true, 500, 0},
+		{"redirects are followed", server.URL + "/redirect", nil, nil, true, 200, 1},
+		{"3xx is up by default", server.URL + "/redirect", nil, nil, false, 302, 1},
+		{"status code listed", server.URL + "/missing", []int{404}, nil, true, 404, 1},
+		{"status code not listed", server.URL + "/ok", []int{204, 404}, nil, true, 200, 0},
+		{"3xx not listed", server.URL + "/redirect", []int{200}, nil, false, 302, 0},
+		{"body matches", server.URL + "/ok", nil, okRegex, true, 200, 1},
+		{"body doesn't match", server.URL + "/degraded", nil, okRegex, true, 200, 0},
+		{"body matches an unexpected status", server.URL + "/error", nil, okRegex, true, 500, 0},
+		{"body matches a listed status", server.URL + "/error", []int{500}, okRegex, true, 500, 1},
+		{"connection refused", closed.URL + "/ok", nil, nil, true, -1, 0},
+	}
+
+	for _, test := range tests {
+		check := &HTTPCheck{
+			Name:            "test",
+			URL:             test.url,
+			Method:          "GET",
+			StatusCodes:     test.statusCodes,
+			BodyRegex:       test.bodyRegex,
+			Timeout:         5 * time.Second,
+			FollowRedirects: test.followRedirects,
+		}
+		check.client = newHTTPCheckClient(check, nil)
+
+		values := make(map[string]float64)
+		for _, metric := range check.Run() {
+			if metric.Tags["check"] != "test" {
+				t.Errorf("%s: %s is tagged %v, want the check name", test.name, metric.Name, metric.Tags)
+			}
+			values[metric.Name] = metric.Value
+		}
+
+		statusCode, ok := values["status_code"]
+		if test.statusCode < 0 && ok {
+			t.Errorf("%s: got status code %v, want none", test.name, statusCode)
+		} else if test.statusCode >= 0 && statusCode != test.statusCode {
+			t.Errorf("%s: got status code %v, want %v", test.name, statusCode, test.statusCode)
+		}
+		if up, ok := values["up"]; !ok || up != test.up {
+			t.Errorf("%s: got up %v, want %v", test.name, up, test.up)
+		}
+		if _, ok := values["duration"]; !ok {
+			t.Errorf("%s: duration is missing", test.name)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const InfluxDBModuleName = "influxdb"
+
+func init() {
+	settings := []ModuleSetting{
+		{"protocol", "http or udp, defaults to http"},
+		{"url", "influxdb url for http"},
+		{"version", "influxdb http api version, 1 or 2, defaults to 1"},
+		{"database", "database for version 1"},
+		{"username", "username for version 1"},
+		{"password", "password for version 1"},
+		{"org", "organization for version 2"},
+		{"bucket", "bucket for version 2"},
+		{"token", "api token for version 2"},
+		{"host", "influxdb host for udp"},
+		{"port", "influxdb port for udp"},
+		{"batch_size", "lines per write, defaults to 5000"},
+		{"timeout", "http timeout in seconds, defaults to 5"},
+		{"max_queue_size", "number of unsent lines to keep for retrying, unlimited by default"},
+		{"spool_dir", "directory to spool unsent lines to instead of keeping them in memory"},
+		{"spool_max_size", "megabytes of spooled lines to keep, 100 by default"},
+		{"spool_max_age", "seconds to keep spooled lines, a day by default"},
+	}
+	RegisterModule(InfluxDBModuleName, func() Module { return &InfluxDBOutputModule{} },
+		append(settings, tlsSettings...)...)
+}
+
+const defaultInfluxDBBatchSize = 5000
+
+// keep udp datagrams under a typical network mtu
+const influxDBMaxUDPPayload = 1400
+
+type InfluxDBOutputModule struct {
+	Protocol     string
+	URL          string
+	Version      int
+	Database     string
+	Username     string
+	Password     string
+	Org          string
+	Bucket       string
+	Token        string
+	Address      string
+	BatchSize    int
+	MaxQueueSize int
+	hostname     string
+	globalTags   map[string]string
+	client       *http.Client
+	conn         net.Conn
+	queue        *MetricQueue
+}
+
+// influxDBPoint collects all metrics from a module that share the same tags and timestamp, so they can
+// be written as the fields of a single line.
+type influxDBPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Timestamp   int64
+	Fields      []string
+}
+
+func (m *InfluxDBOutputModule) Name() string {
+	return InfluxDBModuleName
+}
+
+func (m *InfluxDBOutputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	// parse influxdb settings
+	protocol, err := moduleConfig.SettingsString("protocol")
+	if err != nil || protocol == "" {
+		protocol = "http"
+	}
+	if protocol != "http" && protocol != "udp" {
+		log.Fatalf("InfluxDB protocol %s is not supported", protocol)
+	}
+
+	batchSize, err := moduleConfig.SettingsInt("batch_size")
+	if err != nil || batchSize < 1 {
+		batchSize = defaultInfluxDBBatchSize
+	}
+
+	timeout, err := moduleConfig.SettingsInt("timeout")
+	if err != nil || timeout < 1 {
+		timeout = 5
+	}
+
+	tlsConfig, err := newTLSConfig(moduleConfig)
+	if err != nil {
+		log.Fatalf("Unable to configure influxdb tls: %v", err)
+	}
+	if tlsConfig != nil && protocol == "udp" {
+		log.Fatalf("InfluxDB tls requires the http protocol")
+	}
+
+	if protocol == "udp" {
+		host, err := moduleConfig.SettingsString("host")
+		if err != nil || host == "" {
+			log.Fatalf("host must be specified: %v", err)
+		}
+
+		port, err := moduleConfig.SettingsInt("port")
+		if err != nil {
+			log.Fatalf("Unable to parse port: %v", err)
+		} else if port < 1 || port > 65535 {
+			log.Fatalf("invalid port number: %d", port)
+		}
+
+		m.Address = net.JoinHostPort(host, strconv.Itoa(port))
+	} else {
+		m.URL, err = moduleConfig.SettingsString("url")
+		if err != nil || m.URL == "" {
+			log.Fatalf("url must be specified: %v", err)
+		}
+		m.URL = strings.TrimRight(m.URL, "/")
+
+		m.Version, err = moduleConfig.SettingsInt("version")
+		if err != nil {
+			m.Version = 1
+		}
+
+		switch m.Version {
+		case 1:
+			m.Database, err = moduleConfig.SettingsString("database")
+			if err != nil || m.Database == "" {
+				log.Fatalf("database must be specified: %v", err)
+			}
+			m.Username, _ = moduleConfig.SettingsString("username")
+			m.Password, _ = moduleConfig.SettingsString("password")
+		case 2:
+			m.Org, err = moduleConfig.SettingsString("org")
+			if err != nil || m.Org == "" {
+				log.Fatalf("org must be specified: %v", err)
+			}
+			m.Bucket, err = moduleConfig.SettingsString("bucket")
+			if err != nil || m.Bucket == "" {
+				log.Fatalf("bucket must be specified: %v", err)
+			}
+			m.Token, _ = moduleConfig.SettingsString("token")
+		default:
+			log.Fatalf("InfluxDB version %d is not supported", m.Version)
+		}
+	}
+
+	// save config data
+	m.Protocol = protocol
+	m.BatchSize = batchSize
+	m.hostname = getHostname(config)
+	m.globalTags = config.Tags
+	m.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	if tlsConfig != nil {
+		m.client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	}
+	m.queue = newOutputQueue("InfluxDB", moduleConfig, "")
+	m.MaxQueueSize = m.queue.MaxSize
+
+	// if influxdb can't be reached yet the connection is retried on each send
+	if m.Protocol == "udp" {
+		m.conn, _ = connectToInfluxDB(m.Address)
+	}
+
+	return nil
+}
+
+func (m *InfluxDBOutputModule) TearDown() error {
+	if m.conn != nil {
+		return m.conn.Close()
+	}
+	return nil
+}
+
+func (m *InfluxDBOutputModule) SendMetrics(moduleMetrics []*ModuleMetrics) error {
+	// add the metrics behind any that are already queued
+	m.queue.Push(m.LineProtocol(moduleMetrics))
+
+	return m.sendQueued(time.Time{})
+}
+
+// Flush makes a last attempt to send any queued lines before the deadline
+func (m *InfluxDBOutputModule) Flush(deadline time.Time) error {
+	if m.queue.Len() == 0 {
+		return nil
+	}
+
+	log.Printf("Flushing %d queued influxdb lines", m.queue.Len())
+
+	return m.sendQueued(deadline)
+}
+
+// sendQueued sends queued lines in batches until one fails, anything unsent stays queued.  If the
+// deadline isn't zero no new batch is started after it.
+func (m *InfluxDBOutputModule) sendQueued(deadline time.Time) error {
+	var err error
+
+	// spooled lines are sent a batch at a time
+	for err == nil && m.queue.Len() > 0 {
+		queued := m.queue.Peek()
+		if len(queued) == 0 {
+			break
+		}
+
+		sent := 0
+		for sent < len(queued) {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				err = errors.New("deadline reached with lines still queued")
+				break
+			}
+
+			end := sent + m.BatchSize
+			if end > len(queued) {
+				end = len(queued)
+			}
+
+			if m.Protocol == "udp" {
+				err = m.writeUDP(queued[sent:end], deadline)
+			} else {
+				err = m.writeHTTP(queued[sent:end], deadline)
+			}
+			if err != nil {
+				log.Printf("Error sending influxdb metrics: %v", err)
+				break
+			}
+
+			sent = end
+		}
+		m.queue.Commit(sent)
+	}
+
+	return err
+}
+
+// LineProtocol converts metrics into InfluxDB line protocol.  The module name is used as the
+// measurement, the metric and global tags become tags, and metrics that share tags and a timestamp
+// are combined into the fields of a single line.
+func (m *InfluxDBOutputModule) LineProtocol(moduleMetrics []*ModuleMetrics) []string {
+	points := make([]*influxDBPoint, 0, len(moduleMetrics))
+	index := make(map[string]*influxDBPoint)
+
+	for _, module := range moduleMetrics {
+		for _, metric := range module.Metrics {
+			if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+				continue
+			}
+
+			tags := module.MetricTags(m.globalTags, metric)
+			if _, ok := tags["host"]; !ok {
+				tags["host"] = m.hostname
+			}
+			timestamp := metric.Timestamp.Unix()
+
+			key := fmt.Sprintf("%s%s %d", influxDBEscape(module.Module, ", "), influxDBTags(tags), timestamp)
+			point, ok := index[key]
+			if !ok {
+				point = &influxDBPoint{Measurement: module.Module, Tags: tags, Timestamp: timestamp}
+				index[key] = point
+				points = append(points, point)
+			}
+
+			field := fmt.Sprintf("%s=%s", influxDBEscape(metric.Name, ",= "), strconv.FormatFloat(metric.Value, 'g', -1, 64))
+			point.Fields = append(point.Fields, field)
+		}
+	}
+
+	lines := make([]string, 0, len(points))
+	for _, point := range points {
+		line := fmt.Sprintf("%s%s %s %d\n", influxDBEscape(point.Measurement, ", "), influxDBTags(point.Tags),
+			strings.Join(point.Fields, ","), point.Timestamp)
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func (m *InfluxDBOutputModule) writeHTTP(lines []string, deadline time.Time) error {
+	params := url.Values{}
+	params.Set("precision", "s")
+
+	var endpoint string
+	if m.Version == 2 {
+		endpoint = m.URL + "/api/v2/write"
+		params.Set("org", m.Org)
+		params.Set("bucket", m.Bucket)
+	} else {
+		endpoint = m.URL + "/write"
+		params.Set("db", m.Database)
+	}
+
+	body := strings.Join(lines, "")
+	request, err := http.NewRequest("POST", endpoint+"?"+params.Encode(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if !deadline.IsZero() {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		request = request.WithContext(ctx)
+	}
+	if m.Version == 2 && m.Token != "" {
+		request.Header.Set("Authorization", "Token "+m.Token)
+	} else if m.Username != "" {
+		request.SetBasicAuth(m.Username, m.Password)
+	}
+
+	response, err := m.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	message, _ := ioutil.ReadAll(response.Body)
+
+	switch {
+	case response.StatusCode/100 == 2:
+		return nil
+	case response.StatusCode == http.StatusBadRequest:
+		// influxdb rejected the points themselves, retrying them would fail forever
+		log.Printf("InfluxDB rejected %d lines: %s", len(lines), strings.TrimSpace(string(message)))
+		return nil
+	default:
+		return fmt.Errorf("influxdb returned %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+}
+
+func (m *InfluxDBOutputModule) writeUDP(lines []string, deadline time.Time) error {
+	// attempt to reconnect to influxdb
+	if m.conn == nil {
+		conn, err := connectToInfluxDB(m.Address)
+		if err != nil {
+			return err
+		}
+		log.Print("Reconnected to influxdb")
+		m.conn = conn
+	}
+
+	m.conn.SetWriteDeadline(deadline)
+
+	var payload bytes.Buffer
+	for i, line := range lines {
+		payload.WriteString(line)
+
+		if i == len(lines)-1 || payload.Len()+len(lines[i+1]) > influxDBMaxUDPPayload {
+			_, err := m.conn.Write(payload.Bytes())
+			if err != nil {
+				// close the existing connection
+				m.conn.Close()
+				m.conn = nil
+				return err
+			}
+			payload.Reset()
+		}
+	}
+
+	return nil
+}
+
+func connectToInfluxDB(address string) (net.Conn, error) {
+	conn, err := net.DialTimeout("udp", address, 5*time.Second)
+	if err != nil {
+		log.Printf("Failed to connect to influxdb: %v", err)
+	}
+
+	return conn, err
+}
+
+// influxDBTags returns the sorted, escaped tag set of a line, including the leading comma
+func influxDBTags(tags map[string]string) string {
+	var buffer bytes.Buffer
+	for _, key := range sortedTagKeys(tags) {
+		if key == "" || tags[key] == "" {
+			continue
+		}
+		fmt.Fprintf(&buffer, ",%s=%s", influxDBEscape(key, ",= "), influxDBEscape(tags[key], ",= "))
+	}
+
+	return buffer.String()
+}
+
+// influxDBEscape backslash escapes the given special characters
+func influxDBEscape(value string, special string) string {
+	for _, c := range special {
+		value = strings.Replace(value, string(c), "\\"+string(c), -1)
+	}
+	return value
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInfluxDBLineProtocolEscaping(t *testing.T) {
+	timestamp := time.Unix(1500000000, 0)
+
+	tests := []struct {
+		name     string
+		module   string
+		metric   string
+		tags     map[string]string
+		expected string
+	}{
+		{
+			name:     "plain",
+			module:   "cpu",
+			metric:   "user",
+			tags:     map[string]string{"cpu": "cpu0"},
+			expected: "cpu,cpu=cpu0,host=web1 user=1.5 1500000000\n",
+		},
+		{
+			name:     "measurement commas and spaces",
+			module:   "my module,x",
+			metric:   "user",
+			expected: "my\\ module\\,x,host=web1 user=1.5 1500000000\n",
+		},
+		{
+			name:     "measurement equals and quotes are literal",
+			module:   `a=b"c`,
+			metric:   "user",
+			expected: `a=b"c,host=web1 user=1.5 1500000000` + "\n",
+		},
+		{
+			name:     "tag keys and values",
+			module:   "disk",
+			metric:   "used",
+			tags:     map[string]string{"mount point": "/mnt/a b,c=d"},
+			expected: "disk,host=web1,mount\\ point=/mnt/a\\ b\\,c\\=d used=1.5 1500000000\n",
+		},
+		{
+			name:     "tag quotes are literal",
+			module:   "disk",
+			metric:   "used",
+			tags:     map[string]string{"label": `"quoted"`},
+			expected: `disk,host=web1,label="quoted" used=1.5 1500000000` + "\n",
+		},
+		{
+			name:     "field keys",
+			module:   "exec",
+			metric:   `a b,c=d"e`,
+			expected: `exec,host=web1 a\ b\,c\=d"e=1.5 1500000000` + "\n",
+		},
+		{
+			name:     "empty tag values are dropped",
+			module:   "cpu",
+			metric:   "user",
+			tags:     map[string]string{"cpu": ""},
+			expected: "cpu,host=web1 user=1.5 1500000000\n",
+		},
+	}
+
+	m := &InfluxDBOutputModule{hostname: "web1"}
+	for _, test := range tests {
+		metric := NewTaggedMetric(test.metric, 1.5, test.tags)
+		metric.Timestamp = timestamp
+
+		lines := m.LineProtocol([]*ModuleMetrics{{Module: test.module, Metrics: []Metric{metric}}})
+		if len(lines) != 1 || lines[0] != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, lines)
+		}
+	}
+}
+
+func TestInfluxDBWriteHTTP(t *testing.T) {
+	tests := []struct {
+		name          string
+		module        InfluxDBOutputModule
+		path          string
+		query         url.Values
+		authorization string
+	}{
+		{
+			name:   "version 1",
+			module: InfluxDBOutputModule{Version: 1, Database: "metrics"},
+			path:   "/write",
+			query:  url.Values{"db": {"metrics"}, "precision": {"s"}},
+		},
+		{
+			name:          "version 1 with basic auth",
+			module:        InfluxDBOutputModule{Version: 1, Database: "metrics", Username: "user", Password: "secret"},
+			path:          "/write",
+			query:         url.Values{"db": {"metrics"}, "precision": {"s"}},
+			authorization: "Basic dXNlcjpzZWNyZXQ=",
+		},
+		{
+			name:   "version 2 without a token",
+			module: InfluxDBOutputModule{Version: 2, Org: "my org", Bucket: "metrics"},
+			path:   "/api/v2/write",
+			query:  url.Values{"org": {"my org"}, "bucket": {"metrics"}, "precision": {"s"}},
+		},
+		{
+			name:          "version 2 with a token",
+			module:        InfluxDBOutputModule{Version: 2, Org: "my org", Bucket: "metrics", Token: "abc123"},
+			path:          "/api/v2/write",
+			query:         url.Values{"org": {"my org"}, "bucket": {"metrics"}, "precision": {"s"}},
+			authorization: "Token abc123",
+		},
+	}
+
+	for _, test := range tests {
+		var request *http.Request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request = r
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		m := test.module
+		m.URL = server.URL
+		m.client = server.Client()
+
+		err := m.writeHTTP([]string{"cpu user=1 1500000000\n"}, time.Time{})
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if request.Method != "POST" || request.URL.Path != test.path {
+			t.Errorf("%s: expected POST %s, got %s %s", test.name, test.path, request.Method, request.URL.Path)
+		}
+		if query := request.URL.Query(); query.Encode() != test.query.Encode() {
+			t.Errorf("%s: expected query %s, got %s", test.name, test.query.Encode(), query.Encode())
+		}
+		if authorization := request.Header.Get("Authorization"); authorization != test.authorization {
+			t.Errorf("%s: expected authorization %q, got %q", test.name, test.authorization, authorization)
+		}
+	}
+}
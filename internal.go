@@ -6,6 +6,10 @@ import (
 
 const InternalModuleName = "internal"
 
+func init() {
+	RegisterModule(InternalModuleName, func() Module { return &InternalInputModule{} })
+}
+
 type InternalInputModule struct {
 	lastStats *runtime.MemStats
 }
@@ -38,9 +42,9 @@ func (m *InternalInputModule) GetMetrics() (*ModuleMetrics, error) {
 
 		metrics = append(metrics, NewMetric("general.allocated", float64(memstats.Alloc)))
 		metrics = append(metrics, NewMetric("general.system", float64(memstats.Sys)))
-		metrics = append(metrics, NewMetric("general.lookups", float64(lookups)))
-		metrics = append(metrics, NewMetric("general.mallocs", float64(mallocs)))
-		metrics = append(metrics, NewMetric("general.frees", float64(frees)))
+		metrics = append(metrics, NewRateMetric("general.lookups", float64(lookups), nil))
+		metrics = append(metrics, NewRateMetric("general.mallocs", float64(mallocs), nil))
+		metrics = append(metrics, NewRateMetric("general.frees", float64(frees), nil))
 
 		metrics = append(metrics, NewMetric("heap.allocated", float64(memstats.HeapAlloc)))
 		metrics = append(metrics, NewMetric("heap.system", float64(memstats.HeapSys)))
@@ -60,7 +64,7 @@ func (m *InternalInputModule) GetMetrics() (*ModuleMetrics, error) {
 		}
 
 		metrics = append(metrics, NewMetric("gc.average_gc", averageGC))
-		metrics = append(metrics, NewMetric("gc.num_gc", float64(numGC)))
+		metrics = append(metrics, NewRateMetric("gc.num_gc", float64(numGC), nil))
 	}
 
 	m.lastStats = &memstats
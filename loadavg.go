@@ -6,6 +6,10 @@ import (
 
 const LoadModuleName = "loadavg"
 
+func init() {
+	RegisterModule(LoadModuleName, func() Module { return &LoadInputModule{} })
+}
+
 // LinuxSysinfoLoadsScale magic number
 const LinuxSysinfoLoadsScale = 65536.0
 
@@ -10,7 +10,14 @@ import (
 	"time"
 )
 
+// the instance of the internal module that input module timeouts are reported under
+const timeoutInstance = "collection"
+
+// how long shutdown may take if the config doesn't set shutdown_timeout
+const defaultShutdownTimeout = 10 * time.Second
+
 var configFile = flag.String("c", "", "config file to use")
+var listModulesFlag = flag.Bool("list-modules", false, "list the available modules and their settings")
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
@@ -21,6 +28,11 @@ func usage() {
 func main() {
 	flag.Parse()
 
+	if *listModulesFlag {
+		listModules(os.Stdout)
+		os.Exit(0)
+	}
+
 	if *configFile == "" {
 		usage()
 	}
@@ -30,14 +42,26 @@ func main() {
 	//get all modules
 	modules := getModules(config)
 
+	//start the http api
+	var statusServer *StatusServer
+	if config.HTTPAddress != "" {
+		var err error
+		statusServer, err = startStatusServer(config, modules)
+		if err != nil {
+			log.Fatalf("Failed to start the http api: %v", err)
+		}
+	}
+
 	//start loop
-	ticker := time.NewTicker(time.Duration(config.Interval) * time.Second)
+	ticker := time.NewTicker(modules.TickInterval)
 	quit := make(chan struct{})
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		for {
 			select {
 			case <-ticker.C:
-				tickModules(config, &modules)
+				tickModules(config, modules)
 			case <-quit:
 				ticker.Stop()
 				return
@@ -49,16 +73,29 @@ func main() {
 	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for sig := range sigChan {
-			log.Printf("captured %v, stopping metrics collection and exiting", sig)
+		sig := <-sigChan
+		log.Printf("captured %v, stopping metrics collection and exiting", sig)
+
+		go func() {
+			sig := <-sigChan
+			log.Printf("captured %v during shutdown, exiting immediately", sig)
+			os.Exit(1)
+		}()
 
-			//run any cleanup steps
-			close(quit)
+		//stop the loop, waiting for a running tick to finish
+		close(quit)
+		<-stopped
 
-			tearDownModules(&modules)
+		//run any cleanup steps
+		shutdownModules(config, modules)
 
-			os.Exit(0)
+		if statusServer != nil {
+			statusServer.Close()
 		}
+
+		tearDownModules(modules)
+
+		os.Exit(0)
 	}()
 
 	// run forever
@@ -68,36 +105,153 @@ func main() {
 func tickModules(config Config, modules *Modules) {
 	var start = time.Now()
 
-	allMetrics := []*ModuleMetrics{}
-
-	// send metric requests to the input modules in a non blocking manner
+	// send metric requests to the input modules that are due.  A module that is still working on an
+	// earlier request is skipped, its metrics are shipped with whichever tick they arrive in.
+	waiting := make(map[int]time.Time)
 	for i, c := range modules.InputChannels {
-		module := modules.InputModules[i]
+		if !modules.inputDue(i, start) {
+			continue
+		}
+
+		if modules.inputPending[i] {
+			log.Printf("The %s input module is queuing requests", modules.InputNames[i])
+			continue
+		}
+
 		select {
 		case c <- 1:
+			modules.inputPending[i] = true
+			waiting[i] = start.Add(modules.InputTimeouts[i])
 		default:
-			log.Printf("The %s input module is queuing requests", module.Name())
+			log.Printf("The %s input module is queuing requests", modules.InputNames[i])
 		}
 	}
 
-	// collect all metrics that are available
-	collectMetrics := true
-	for collectMetrics {
-		select {
-		case metrics := <-modules.InputResponseChan:
-			allMetrics = append(allMetrics, metrics)
-		default:
-			collectMetrics = false
+	allMetrics := collectMetrics(modules, waiting)
+
+	// nothing to transform or send if no modules were due, but the tick still counts for /health
+	if len(allMetrics) == 0 {
+		modules.SetLatestMetrics(allMetrics)
+		return
+	}
+
+	sendMetrics(modules, allMetrics)
+
+	// check to make sure the metrics collection isn't taking too long
+	maxTime := modules.TickInterval.Seconds() * 0.9
+	tickTime := time.Since(start).Seconds()
+	if tickTime >= maxTime {
+		log.Printf("getInputMetrics took %f seconds", tickTime)
+	}
+}
+
+// shutdownModules runs a final tick that collects every input module one last time, along with any
+// that are still collecting, and gives the output modules a chance to flush their queued metrics.
+// Everything has to finish within the shutdown timeout.
+func shutdownModules(config Config, modules *Modules) {
+	timeout := defaultShutdownTimeout
+	if config.ShutdownTimeout > 0 {
+		timeout = secondsToDuration(config.ShutdownTimeout)
+	}
+	deadline := time.Now().Add(timeout)
+
+	// request a last collection from every input module and wait for it, along with the requests
+	// already in flight, but leave time for the outputs
+	collectDeadline := time.Now().Add(timeout / 2)
+	waiting := make(map[int]time.Time)
+	for i, c := range modules.InputChannels {
+		if !modules.inputPending[i] {
+			select {
+			case c <- 1:
+				modules.inputPending[i] = true
+			default:
+				continue
+			}
 		}
+		waiting[i] = collectDeadline
 	}
 
-	// transform metrics
-	for _, e := range modules.TransformModules {
-		_, ok := e.(TransformModule)
+	allMetrics := collectMetrics(modules, waiting)
+	if len(allMetrics) > 0 {
+		sendMetrics(modules, allMetrics)
+	}
+
+	for _, e := range modules.OutputModules {
+		module, ok := e.(FlushOutputModule)
 		if !ok {
-			log.Printf("%s is not an TransformModule", e.Name())
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			log.Printf("Shutdown timeout reached before %s was flushed", e.Name())
+			continue
+		}
+
+		err := module.Flush(deadline)
+		if err != nil {
+			log.Printf("Failed to flush %s: %v", e.Name(), err)
 		}
 	}
+}
+
+// collectMetrics waits for the input modules in waiting to respond, giving up on each one at its
+// deadline, and then picks up any metrics from modules that timed out on an earlier tick.
+func collectMetrics(modules *Modules, waiting map[int]time.Time) []*ModuleMetrics {
+	allMetrics := []*ModuleMetrics{}
+	timedOut := false
+
+	for len(waiting) > 0 {
+		var deadline time.Time
+		for _, d := range waiting {
+			if deadline.IsZero() || d.Before(deadline) {
+				deadline = d
+			}
+		}
+
+		timer := time.NewTimer(deadline.Sub(time.Now()))
+		select {
+		case response := <-modules.InputResponseChan:
+			allMetrics = appendInputResponse(modules, allMetrics, response)
+			delete(waiting, response.Index)
+		case <-timer.C:
+			now := time.Now()
+			for i, d := range waiting {
+				if now.Before(d) {
+					continue
+				}
+				log.Printf("The %s input module timed out", modules.InputNames[i])
+				modules.inputTimeoutCount[i]++
+				timedOut = true
+				delete(waiting, i)
+			}
+		}
+		timer.Stop()
+	}
+
+	if timedOut {
+		allMetrics = append(allMetrics, timeoutMetrics(modules))
+	}
+
+	// collect any metrics from modules that timed out on an earlier tick
+	collect := true
+	for collect {
+		select {
+		case response := <-modules.InputResponseChan:
+			allMetrics = appendInputResponse(modules, allMetrics, response)
+		default:
+			collect = false
+		}
+	}
+
+	return allMetrics
+}
+
+// sendMetrics transforms the collected metrics and sends them to every output module
+func sendMetrics(modules *Modules, allMetrics []*ModuleMetrics) {
+	// transform metrics
+	allMetrics = transformMetrics(modules, allMetrics)
+
+	modules.SetLatestMetrics(allMetrics)
 
 	// send metrics
 	for _, e := range modules.OutputModules {
@@ -105,14 +259,78 @@ func tickModules(config Config, modules *Modules) {
 		if !ok {
 			log.Printf("%s is not an OutputModule", e.Name())
 		} else {
-			module.SendMetrics(allMetrics)
+			sendStart := time.Now()
+			err := module.SendMetrics(allMetrics)
+			modules.Status(e).Record(sendStart, err)
 		}
 	}
+}
 
-	// check to make sure the metrics collection isn't taking too long
-	maxTime := float64(config.Interval) * 0.9
-	tickTime := time.Since(start).Seconds()
-	if tickTime >= maxTime {
-		log.Printf("getInputMetrics took %f seconds", tickTime)
+// appendInputResponse marks the responding input module as ready for new requests and adds its
+// metrics, if it returned any.
+func appendInputResponse(modules *Modules, allMetrics []*ModuleMetrics, response *InputResponse) []*ModuleMetrics {
+	modules.inputPending[response.Index] = false
+	if response.Metrics == nil {
+		return allMetrics
+	}
+	return append(allMetrics, response.Metrics)
+}
+
+// timeoutMetrics reports how many times each input module that has timed out didn't respond within
+// its timeout, tagged with the id of the module.  The counters are reported under the collection
+// instance of the internal module, so they don't replace the internal module's own latest metrics.
+func timeoutMetrics(modules *Modules) *ModuleMetrics {
+	metrics := make([]Metric, 0, len(modules.inputTimeoutCount))
+	for i, count := range modules.inputTimeoutCount {
+		if count == 0 {
+			continue
+		}
+		tags := map[string]string{"module": modules.InputNames[i]}
+		metrics = append(metrics, NewCounterMetric("timeouts", count, tags))
+	}
+	return &ModuleMetrics{Module: InternalModuleName, Instance: timeoutInstance, Metrics: metrics}
+}
+
+// transformMetrics passes the collected metrics through each transform module in order.  A transform
+// only receives the metrics from the input modules listed in its config, or all metrics if none are
+// listed, and the metrics it returns replace the ones it was given.  If a transform fails the metrics
+// it was given are passed along unchanged.
+func transformMetrics(modules *Modules, allMetrics []*ModuleMetrics) []*ModuleMetrics {
+	for i, e := range modules.TransformModules {
+		module, ok := e.(TransformModule)
+		if !ok {
+			log.Printf("%s is not an TransformModule", e.Name())
+			continue
+		}
+
+		inputs := StringSet{}
+		inputs.AddAll(modules.TransformInputs[i])
+		allInputs := len(modules.TransformInputs[i]) == 0
+
+		selected := make([]*ModuleMetrics, 0, len(allMetrics))
+		remaining := make([]*ModuleMetrics, 0, len(allMetrics))
+		for _, metrics := range allMetrics {
+			if allInputs || inputs.Contains(metrics.Module) || inputs.Contains(metrics.ID()) {
+				selected = append(selected, metrics)
+			} else {
+				remaining = append(remaining, metrics)
+			}
+		}
+
+		if len(selected) == 0 {
+			continue
+		}
+
+		start := time.Now()
+		transformed, err := module.TransformMetrics(selected)
+		modules.Status(e).Record(start, err)
+		if err != nil {
+			log.Printf("Failed to transform metrics with %s: %v", e.Name(), err)
+			continue
+		}
+
+		allMetrics = append(remaining, transformed...)
 	}
+
+	return allMetrics
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMetricsKeepInternalMetrics(t *testing.T) {
+	modules := &Modules{
+		InputNames:        []string{"redis.cache", "memcached"},
+		InputResponseChan: make(chan *InputResponse, 2),
+		inputPending:      []bool{true, true},
+		inputTimeoutCount: []float64{0, 0},
+		latestMetrics:     make(map[string]*ModuleMetrics),
+	}
+
+	internal := &ModuleMetrics{Module: InternalModuleName, Metrics: []Metric{NewMetric("heap.objects", 10)}}
+	modules.SetLatestMetrics([]*ModuleMetrics{internal})
+
+	// both modules time out twice, the second one once more
+	for _, timeouts := range [][]int{{0, 1}, {0, 1}, {1}} {
+		waiting := make(map[int]time.Time)
+		for _, i := range timeouts {
+			waiting[i] = time.Now()
+		}
+		modules.SetLatestMetrics(collectMetrics(modules, waiting))
+	}
+
+	latest, _ := modules.LatestMetrics()
+	if len(latest) != 2 {
+		t.Fatalf("expected the internal metrics and the timeouts, got %d modules", len(latest))
+	}
+	if latest[0] != internal {
+		t.Errorf("expected the internal module metrics to be kept, got %+v", latest[0])
+	}
+
+	timeouts := latest[1]
+	if timeouts.ID() == internal.ID() {
+		t.Errorf("expected the timeouts to have their own id, got %s", timeouts.ID())
+	}
+
+	expected := map[string]float64{"redis.cache": 2, "memcached": 3}
+	if len(timeouts.Metrics) != len(expected) {
+		t.Fatalf("expected %d timeout counters, got %+v", len(expected), timeouts.Metrics)
+	}
+	for _, metric := range timeouts.Metrics {
+		if metric.Name != "timeouts" || metric.Type != CounterMetric {
+			t.Errorf("expected a timeouts counter, got %+v", metric)
+		}
+		if metric.Value != expected[metric.Tags["module"]] {
+			t.Errorf("expected %v timeouts for %s, got %v", expected[metric.Tags["module"]], metric.Tags["module"], metric.Value)
+		}
+	}
+}
+
+// testInputModule is an input module whose collections block until release is closed
+type testInputModule struct {
+	name      string
+	release   chan struct{}
+	collected int
+	tornDown  bool
+}
+
+func (m *testInputModule) Init(config *Config, moduleConfig *ModuleConfig) error { return nil }
+func (m *testInputModule) Name() string                                          { return m.name }
+
+func (m *testInputModule) TearDown() error {
+	m.tornDown = true
+	return nil
+}
+
+func (m *testInputModule) GetMetrics() (*ModuleMetrics, error) {
+	<-m.release
+	m.collected++
+	return &ModuleMetrics{Module: m.name, Metrics: []Metric{NewMetric("collected", float64(m.collected))}}, nil
+}
+
+// testOutputModule keeps every metric sent to it
+type testOutputModule struct {
+	sent []*ModuleMetrics
+}
+
+func (m *testOutputModule) Init(config *Config, moduleConfig *ModuleConfig) error { return nil }
+func (m *testOutputModule) Name() string                                          { return "test_output" }
+func (m *testOutputModule) TearDown() error                                       { return nil }
+
+func (m *testOutputModule) SendMetrics(moduleMetrics []*ModuleMetrics) error {
+	m.sent = append(m.sent, moduleMetrics...)
+	return nil
+}
+
+func TestShutdownCollectsEveryInput(t *testing.T) {
+	ready := make(chan struct{})
+	close(ready)
+
+	idle := &testInputModule{name: "idle", release: ready}
+	stuck := &testInputModule{name: "stuck", release: make(chan struct{})}
+	output := &testOutputModule{}
+
+	modules := &Modules{
+		InputResponseChan: make(chan *InputResponse, 4),
+		OutputModules:     []Module{output},
+		statusIndex:       make(map[Module]*ModuleStatus),
+		latestMetrics:     make(map[string]*ModuleMetrics),
+	}
+	for i, module := range []*testInputModule{idle, stuck} {
+		status := modules.addStatus(module, module.name, InputModuleKind)
+		c, err := InitInputModule(module, i, "", status, modules.InputResponseChan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modules.InputModules = append(modules.InputModules, module)
+		modules.InputNames = append(modules.InputNames, module.name)
+		modules.InputChannels = append(modules.InputChannels, c)
+		modules.inputPending = append(modules.inputPending, false)
+		modules.inputTimeoutCount = append(modules.inputTimeoutCount, 0)
+	}
+	modules.addStatus(output, output.Name(), OutputModuleKind)
+
+	start := time.Now()
+	shutdownModules(Config{ShutdownTimeout: 0.2}, modules)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected shutdown to finish within its timeout, took %v", elapsed)
+	}
+
+	sent := StringSet{}
+	for _, metrics := range output.sent {
+		sent.Add(metrics.ID())
+	}
+	if !sent.Contains("idle") {
+		t.Errorf("expected a last collection of the idle module to be sent, got %v", output.sent)
+	}
+	if sent.Contains("stuck") {
+		t.Errorf("expected the stuck module to time out, got %v", output.sent)
+	}
+
+	tearDownModules(modules)
+	if !idle.tornDown {
+		t.Error("expected the idle module to be torn down")
+	}
+	if stuck.tornDown {
+		t.Error("expected the stuck module not to be torn down while it's collecting")
+	}
+	close(stuck.release)
+}
+
+func TestHealthStaysOkBetweenDueModules(t *testing.T) {
+	modules := &Modules{
+		InputChannels:     []chan int{make(chan int, 1)},
+		InputNames:        []string{"slow"},
+		InputIntervals:    []time.Duration{time.Minute},
+		InputTimeouts:     []time.Duration{time.Second},
+		TickInterval:      100 * time.Millisecond,
+		inputNextRun:      []time.Time{time.Now().Add(time.Minute)},
+		inputPending:      []bool{false},
+		inputTimeoutCount: []float64{0},
+		latestMetrics:     make(map[string]*ModuleMetrics),
+	}
+	server := &StatusServer{
+		config:  Config{Interval: 0.1},
+		modules: modules,
+		started: time.Now().Add(-time.Hour),
+	}
+
+	// the module isn't due, so the tick collects nothing
+	tickModules(server.config, modules)
+	if len(modules.InputChannels[0]) != 0 {
+		t.Fatal("the module was collected before it was due")
+	}
+
+	recorder := httptest.NewRecorder()
+	server.handleHealth(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("got status %d, want 200 after a tick with no module due: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// a fractional interval isn't truncated to no time at all
+	time.Sleep(350 * time.Millisecond)
+	recorder = httptest.NewRecorder()
+	server.handleHealth(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want 503 once ticks stop for three intervals", recorder.Code)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MemcachedModuleName = "memcached"
+
+const (
+	defaultMemcachedConnectTimeout = 5
+	defaultMemcachedTimeout        = 5
+)
+
+// cumulative stats that are also reported as per second rates, named with a _per_second suffix
+var memcachedCounters = []string{
+	"cmd_get",
+	"cmd_set",
+	"cmd_flush",
+	"cmd_touch",
+	"get_hits",
+	"get_misses",
+	"get_expired",
+	"get_flushed",
+	"delete_hits",
+	"delete_misses",
+	"incr_hits",
+	"incr_misses",
+	"decr_hits",
+	"decr_misses",
+	"cas_hits",
+	"cas_misses",
+	"touch_hits",
+	"touch_misses",
+	"evictions",
+	"reclaimed",
+	"expired_unfetched",
+	"evicted_unfetched",
+	"bytes_read",
+	"bytes_written",
+	"total_connections",
+	"rejected_connections",
+	"total_items",
+	"rusage_user",
+	"rusage_system",
+}
+
+func init() {
+	settings := []ModuleSetting{
+		{"host", "memcached host"},
+		{"port", "memcached port"},
+		{"socket", "unix socket path, used instead of host and port"},
+		{"connect_timeout", "seconds to wait when connecting, 5 by default"},
+		{"timeout", "seconds to wait for each command, 5 by default"},
+		{"slabs", "collect per slab class stats from stats slabs"},
+		{"items", "collect per slab class item stats from stats items"},
+	}
+	RegisterModule(MemcachedModuleName, func() Module { return &MemcachedInputModule{} },
+		append(settings, tlsSettings...)...)
+}
+
+type MemcachedInputModule struct {
+	Host           string
+	Port           int
+	Socket         string
+	ConnectTimeout time.Duration
+	Timeout        time.Duration
+	Slabs          bool
+	Items          bool
+	conn           net.Conn
+	reader         *bufio.Reader
+	tlsConfig      *tls.Config
+	counters       StringSet
+	rates          CounterRates
+}
+
+func (m *MemcachedInputModule) Name() string {
+	return MemcachedModuleName
+}
+
+func (m *MemcachedInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	// parse memcached settings, a unix socket is used instead of a host and port
+	socket, _ := moduleConfig.SettingsString("socket")
+
+	var host string
+	var port int
+	var err error
+	if socket == "" {
+		host, err = moduleConfig.SettingsString("host")
+		if err != nil || host == "" {
+			log.Fatalf("host must be specified: %v", err)
+		}
+
+		port, err = moduleConfig.SettingsInt("port")
+		if err != nil {
+			log.Fatalf("Unable to parse port: %v", err)
+		} else if port < 1 || port > 65535 {
+			log.Fatalf("invalid port number: %d", port)
+		}
+	}
+
+	connectTimeout, err := moduleConfig.SettingsFloat("connect_timeout")
+	if err != nil || connectTimeout <= 0 {
+		connectTimeout = defaultMemcachedConnectTimeout
+	}
+
+	timeout, err := moduleConfig.SettingsFloat("timeout")
+	if err != nil || timeout <= 0 {
+		timeout = defaultMemcachedTimeout
+	}
+
+	tlsConfig, err := newTLSConfig(moduleConfig)
+	if err != nil {
+		log.Fatalf("Unable to configure memcached tls: %v", err)
+	}
+
+	// save config data
+	m.Host = host
+	m.Port = port
+	m.Socket = socket
+	m.ConnectTimeout = secondsToDuration(connectTimeout)
+	m.Timeout = secondsToDuration(timeout)
+	m.Slabs, _ = moduleConfig.SettingsBool("slabs")
+	m.Items, _ = moduleConfig.SettingsBool("items")
+	m.tlsConfig = tlsConfig
+	m.counters.AddAll(memcachedCounters)
+
+	// connect to memcached, if it isn't up yet the connection is retried on each collection
+	m.conn, err = connectToMemcached(m)
+	if err == nil {
+		m.reader = bufio.NewReader(m.conn)
+	}
+
+	return nil
+}
+
+func (m *MemcachedInputModule) TearDown() error {
+	if m.conn != nil {
+		return m.conn.Close()
+	}
+	return nil
+}
+
+func (m *MemcachedInputModule) GetMetrics() (*ModuleMetrics, error) {
+	stats, err := m.stats("")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	metrics := make([]Metric, 0, 64)
+	for _, stat := range stats {
+		value, err := strconv.ParseFloat(stat[1], 64)
+		if err != nil {
+			continue
+		}
+
+		metrics = append(metrics, NewMetric(stat[0], value))
+
+		if m.counters.Contains(stat[0]) {
+			m.rates.Add(Counter{Name: stat[0] + "_per_second", Value: value})
+		}
+	}
+
+	if m.Slabs {
+		stats, err := m.stats("slabs")
+		if err != nil {
+			return nil, err
+		}
+		// format 1:chunk_size 96, or active_slabs 1 for the totals
+		metrics = append(metrics, memcachedSlabMetrics(stats, "")...)
+	}
+
+	if m.Items {
+		stats, err := m.stats("items")
+		if err != nil {
+			return nil, err
+		}
+		// format items:1:number 5
+		for i := range stats {
+			stats[i][0] = strings.TrimPrefix(stats[i][0], "items:")
+		}
+		metrics = append(metrics, memcachedSlabMetrics(stats, "items_")...)
+	}
+
+	metrics = append(metrics, m.rates.Rates(now)...)
+	if ratio, ok := m.rates.Ratio("get_hits_per_second", "get_misses_per_second"); ok {
+		metrics = append(metrics, NewMetric("hit_ratio", ratio))
+	}
+
+	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+}
+
+// stats runs a stats command and returns the name and value of each STAT line, reconnecting first if
+// the connection was lost
+func (m *MemcachedInputModule) stats(args string) ([][2]string, error) {
+	// attempt to reconnect to memcached
+	if m.conn == nil {
+		conn, err := connectToMemcached(m)
+		if err != nil {
+			return nil, err
+		}
+		log.Print("Reconnected to memcached")
+		m.conn = conn
+		m.reader = bufio.NewReader(conn)
+	}
+
+	stats, err := m.readStats(args)
+	if err != nil {
+		log.Printf("Error collecting metrics from memcached: %v", err)
+
+		// close the existing connection, a partly read response can't be recovered from
+		m.conn.Close()
+		m.conn = nil
+		m.reader = nil
+
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (m *MemcachedInputModule) readStats(args string) ([][2]string, error) {
+	m.conn.SetDeadline(time.Now().Add(m.Timeout))
+
+	command := "stats\r\n"
+	if args != "" {
+		command = fmt.Sprintf("stats %s\r\n", args)
+	}
+	_, err := m.conn.Write([]byte(command))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([][2]string, 0, 64)
+	for {
+		line, err := m.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "END" {
+			return stats, nil
+		}
+		if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+			return nil, errors.New(line)
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			continue
+		}
+		stats = append(stats, [2]string{fields[1], fields[2]})
+	}
+}
+
+// memcachedSlabMetrics converts slab stats into metrics.  Stats named class:name are tagged with the
+// slab class, and the rest are totals.  The prefix is added to every metric name.
+func memcachedSlabMetrics(stats [][2]string, prefix string) []Metric {
+	metrics := make([]Metric, 0, len(stats))
+
+	for _, stat := range stats {
+		value, err := strconv.ParseFloat(stat[1], 64)
+		if err != nil {
+			continue
+		}
+
+		fields := strings.SplitN(stat[0], ":", 2)
+		if len(fields) == 2 {
+			tags := map[string]string{"slab": fields[0]}
+			metrics = append(metrics, NewTaggedMetric(prefix+fields[1], value, tags))
+		} else {
+			metrics = append(metrics, NewMetric(prefix+stat[0], value))
+		}
+	}
+
+	return metrics
+}
+
+func connectToMemcached(m *MemcachedInputModule) (net.Conn, error) {
+	network, address := "tcp", fmt.Sprintf("%s:%d", m.Host, m.Port)
+	if m.Socket != "" {
+		network, address = "unix", m.Socket
+	}
+
+	conn, err := dialTimeout(network, address, m.ConnectTimeout, m.tlsConfig)
+	if err != nil {
+		log.Printf("Failed to connect to memcached: %v", err)
+	}
+
+	return conn, err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newFakeMemcached returns a module connected to a fake memcached, which answers each command it
+// reads with the next of its responses and sends the commands on the channel returned
+func newFakeMemcached(responses ...string) (*MemcachedInputModule, chan string) {
+	client, server := net.Pipe()
+	commands := make(chan string, len(responses))
+
+	go func() {
+		defer close(commands)
+		defer server.Close()
+		reader := bufio.NewReader(server)
+
+		for _, response := range responses {
+			command, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			commands <- command
+
+			_, err = server.Write([]byte(response))
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	m := &MemcachedInputModule{conn: client, reader: bufio.NewReader(client), Timeout: time.Second}
+	return m, commands
+}
+
+func TestMemcachedReadStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     string
+		response string
+		command  string
+		stats    [][2]string
+		err      string
+	}{
+		{
+			name:     "stats",
+			response: "STAT pid 1\r\nSTAT version 1.6.21\r\nSTAT get_hits 10\r\nEND\r\n",
+			command:  "stats\r\n",
+			stats:    [][2]string{{"pid", "1"}, {"version", "1.6.21"}, {"get_hits", "10"}},
+		},
+		{
+			name:     "slabs",
+			args:     "slabs",
+			response: "STAT 1:chunk_size 96\r\nSTAT active_slabs 1\r\nEND\r\n",
+			command:  "stats slabs\r\n",
+			stats:    [][2]string{{"1:chunk_size", "96"}, {"active_slabs", "1"}},
+		},
+		{
+			name:     "malformed lines are skipped",
+			response: "STAT pid\r\nVALUE pid 1\r\nSTAT rusage user 0.1\r\nSTAT threads 4\r\nEND\r\n",
+			command:  "stats\r\n",
+			stats:    [][2]string{{"threads", "4"}},
+		},
+		{
+			name:     "no stats",
+			response: "END\r\n",
+			command:  "stats\r\n",
+			stats:    [][2]string{},
+		},
+		{
+			name:     "error",
+			args:     "unknown",
+			response: "ERROR\r\n",
+			command:  "stats unknown\r\n",
+			err:      "ERROR",
+		},
+		{
+			name:     "client error after stats",
+			response: "STAT pid 1\r\nCLIENT_ERROR bad command line format\r\n",
+			command:  "stats\r\n",
+			err:      "CLIENT_ERROR bad command line format",
+		},
+		{
+			name:     "server error",
+			response: "SERVER_ERROR out of memory\r\n",
+			command:  "stats\r\n",
+			err:      "SERVER_ERROR out of memory",
+		},
+	}
+
+	for _, test := range tests {
+		m, commands := newFakeMemcached(test.response)
+
+		stats, err := m.readStats(test.args)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		} else if !reflect.DeepEqual(stats, test.stats) {
+			t.Errorf("%s: got stats %v, want %v", test.name, stats, test.stats)
+		}
+
+		if command := <-commands; command != test.command {
+			t.Errorf("%s: sent %q, want %q", test.name, command, test.command)
+		}
+		m.conn.Close()
+	}
+}
+
+func TestMemcachedReadStatsTruncated(t *testing.T) {
+	m, _ := newFakeMemcached("STAT pid 1\r\n")
+
+	_, err := m.stats("")
+	if err == nil {
+		t.Fatal("a response cut off before END didn't return an error")
+	}
+	if m.conn != nil || m.reader != nil {
+		t.Error("the connection wasn't dropped after a partly read response")
+	}
+}
+
+func TestMemcachedGetMetrics(t *testing.T) {
+	m, _ := newFakeMemcached(
+		"STAT version 1.6.21\r\nSTAT curr_connections 2\r\nSTAT get_hits 10\r\nEND\r\n",
+		"STAT 1:chunk_size 96\r\nSTAT active_slabs 1\r\nEND\r\n",
+		"STAT items:1:number 5\r\nEND\r\n",
+	)
+	defer m.conn.Close()
+	m.Slabs = true
+	m.Items = true
+	m.counters = StringSet{}
+	m.counters.AddAll(memcachedCounters)
+
+	moduleMetrics, err := m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+
+	got := make(map[string]float64, len(moduleMetrics.Metrics))
+	for _, metric := range moduleMetrics.Metrics {
+		got[metric.FlatName()] = metric.Value
+	}
+	// version isn't numeric, and the first collection has no rates
+	want := map[string]float64{
+		"curr_connections": 2,
+		"get_hits":         10,
+		"1.chunk_size":     96,
+		"active_slabs":     1,
+		"1.items_number":   5,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got metrics %v, want %v", got, want)
+	}
+}
@@ -2,13 +2,20 @@ package main
 
 const MemoryModuleName = "memory"
 
-type MemoryInputModule struct{}
+func init() {
+	RegisterModule(MemoryModuleName, func() Module { return &MemoryInputModule{} })
+}
+
+type MemoryInputModule struct {
+	meminfoPath string
+}
 
 func (m *MemoryInputModule) Name() string {
 	return MemoryModuleName
 }
 
 func (m *MemoryInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	m.meminfoPath = config.HostProc("meminfo")
 	return nil
 }
 
@@ -11,7 +11,7 @@ import (
 func (m *MemoryInputModule) GetMetrics() (*ModuleMetrics, error) {
 	metrics := make([]Metric, 0, 50)
 
-	meminfo, err := ParseMeminfo("/proc/meminfo")
+	meminfo, err := ParseMeminfo(m.meminfoPath)
 	if err != nil {
 		return nil, err
 	}
@@ -1,19 +1,86 @@
 package main
 
 import (
+	"encoding/json"
+	"sort"
+	"strings"
 	"time"
 )
 
+// InstanceTag is the tag used by outputs that support tags to identify the instance of a module
+const InstanceTag = "instance"
+
+// ModuleMetrics holds the metrics collected from a module.  Instance is set when more than one of the
+// same module is configured, and is filled in by the daemon rather than the module.
 type ModuleMetrics struct {
-	Module  string
-	Metrics []Metric
+	Module   string   `json:"module"`
+	Instance string   `json:"instance,omitempty"`
+	Metrics  []Metric `json:"metrics"`
+	// Details is anything else a module wants to show in the http api with its metrics, like the
+	// processes behind the top process metrics.  It isn't sent to the output modules.
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ID returns the module name including the instance, e.g. redis.cache
+func (mm *ModuleMetrics) ID() string {
+	return moduleID(mm.Module, mm.Instance)
 }
 
-// Metric stores all data related to a system metric
+// MetricTags returns the tags of a metric for outputs that support tags, combining the global tags,
+// the module instance, and the metric tags.
+func (mm *ModuleMetrics) MetricTags(global map[string]string, metric Metric) map[string]string {
+	tags := MergeTags(global, metric.Tags)
+	if mm.Instance != "" {
+		tags[InstanceTag] = mm.Instance
+	}
+	return tags
+}
+
+// MetricType describes how the value of a metric was derived
+type MetricType int
+
+const (
+	// GaugeMetric is a value sampled at the time of collection
+	GaugeMetric MetricType = iota
+	// RateMetric is the change in a cumulative counter since the previous collection
+	RateMetric
+	// CounterMetric is a cumulative value that only goes up, until the process keeping it restarts
+	CounterMetric
+)
+
+func (t MetricType) String() string {
+	switch t {
+	case RateMetric:
+		return "rate"
+	case CounterMetric:
+		return "counter"
+	}
+	return "gauge"
+}
+
+// MarshalText encodes the metric type by name, so it reads as gauge, rate, or counter in json
+func (t MetricType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Metric stores all data related to a system metric.  Tags hold the dimensions of the metric, like the
+// cpu or device it was collected for, and should be treated as read only once the metric is created.
 type Metric struct {
-	Name      string
-	Value     float64
-	Timestamp time.Time
+	Name      string            `json:"name"`
+	Value     float64           `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Type      MetricType        `json:"type"`
+}
+
+// MarshalJSON encodes the metric with a value of null if it isn't finite, which a module can produce
+// from a division or a parsed NaN, rather than failing to encode it
+func (m Metric) MarshalJSON() ([]byte, error) {
+	type metric Metric
+	return json.Marshal(struct {
+		metric
+		Value jsonFloat `json:"value"`
+	}{metric(m), jsonFloat(m.Value)})
 }
 
 // NewMetric creates a new Metric structure and automatically sets the timestamp
@@ -24,3 +91,154 @@ func NewMetric(name string, value float64) Metric {
 	m.Timestamp = time.Now()
 	return m
 }
+
+// NewTaggedMetric creates a new Metric structure with the given tags and automatically sets the timestamp
+func NewTaggedMetric(name string, value float64, tags map[string]string) Metric {
+	m := NewMetric(name, value)
+	m.Tags = tags
+	return m
+}
+
+// NewRateMetric creates a new Metric structure for a value derived from a cumulative counter and
+// automatically sets the timestamp.  The tags may be nil.
+func NewRateMetric(name string, value float64, tags map[string]string) Metric {
+	m := NewTaggedMetric(name, value, tags)
+	m.Type = RateMetric
+	return m
+}
+
+// NewCounterMetric creates a new Metric structure for a cumulative counter and automatically sets the
+// timestamp.  The tags may be nil.
+func NewCounterMetric(name string, value float64, tags map[string]string) Metric {
+	m := NewTaggedMetric(name, value, tags)
+	m.Type = CounterMetric
+	return m
+}
+
+// TagKeys returns the metric tag keys in sorted order
+func (m Metric) TagKeys() []string {
+	return sortedTagKeys(m.Tags)
+}
+
+// FlatName returns the dotted name of the metric with the tag values prepended in tag key order, so
+// user{cpu=cpu0} becomes cpu0.user.  This is the form used by outputs that don't support tags.
+func (m Metric) FlatName() string {
+	if len(m.Tags) == 0 {
+		return m.Name
+	}
+
+	parts := make([]string, 0, len(m.Tags)+1)
+	for _, key := range m.TagKeys() {
+		parts = append(parts, m.Tags[key])
+	}
+	parts = append(parts, m.Name)
+
+	return strings.Join(parts, ".")
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MergeTags combines the global tags from the main config with the tags of a metric.  Metric tags take
+// precedence over global tags with the same key.
+func MergeTags(global map[string]string, tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(global)+len(tags))
+	for key, value := range global {
+		merged[key] = value
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+	return merged
+}
+
+// Counter is a cumulative value, like the total requests served, that a module reports the rate of.
+// Name is the name of the rate metric, and the rate is multiplied by Scale if it isn't zero, e.g. to
+// turn microseconds of cpu per second into a percent.
+type Counter struct {
+	Name  string
+	Tags  map[string]string
+	Value float64
+	Scale float64
+}
+
+// CounterRates derives the per second rates of a module's counters between collections.  Counters are
+// added while metrics are collected and Rates is called once they all are.  Counters that are new, or
+// went backwards because whatever keeps them restarted, are skipped until the next collection.
+type CounterRates struct {
+	counters     map[string]Counter
+	previous     map[string]Counter
+	previousTime time.Time
+	deltas       map[string]float64
+}
+
+// Add records the value of a counter for the current collection
+func (r *CounterRates) Add(counter Counter) {
+	if r.counters == nil {
+		r.counters = make(map[string]Counter)
+	}
+	r.counters[counterKey(counter.Name, counter.Tags)] = counter
+}
+
+// Rates returns the rate of each counter added since the last call, and starts the next collection
+func (r *CounterRates) Rates(now time.Time) []Metric {
+	metrics := make([]Metric, 0, len(r.counters))
+	deltas := make(map[string]float64, len(r.counters))
+
+	elapsed := now.Sub(r.previousTime).Seconds()
+	if r.previous != nil && elapsed > 0 {
+		for key, counter := range r.counters {
+			previous, ok := r.previous[key]
+			if !ok || counter.Value < previous.Value {
+				continue
+			}
+
+			delta := counter.Value - previous.Value
+			deltas[key] = delta
+
+			scale := counter.Scale
+			if scale == 0 {
+				scale = 1
+			}
+			metrics = append(metrics, NewRateMetric(counter.Name, delta/elapsed*scale, counter.Tags))
+		}
+	}
+
+	r.previous = r.counters
+	if r.previous == nil {
+		r.previous = make(map[string]Counter)
+	}
+	r.counters = make(map[string]Counter, len(r.previous))
+	r.previousTime = now
+	r.deltas = deltas
+
+	return metrics
+}
+
+// Ratio returns the share of hits in the hits and misses since the collection before the last call to
+// Rates, e.g. the share of lookups that hit a cache.  hits and misses are the names of untagged
+// counters.  It's false if either counter has no rate, or neither changed.
+func (r *CounterRates) Ratio(hits string, misses string) (float64, bool) {
+	hitDelta, hitsOk := r.deltas[counterKey(hits, nil)]
+	missDelta, missesOk := r.deltas[counterKey(misses, nil)]
+	if !hitsOk || !missesOk || hitDelta+missDelta == 0 {
+		return 0, false
+	}
+	return hitDelta / (hitDelta + missDelta), true
+}
+
+// counterKey identifies a counter by its name and tags, e.g. calls_per_second|command=get
+func counterKey(name string, tags map[string]string) string {
+	parts := make([]string, 0, len(tags)+1)
+	parts = append(parts, name)
+	for _, key := range sortedTagKeys(tags) {
+		parts = append(parts, key+"="+tags[key])
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounterRates(t *testing.T) {
+	var rates CounterRates
+	start := time.Now()
+	tags := map[string]string{"command": "get"}
+
+	rates.Add(Counter{Name: "hits_per_second", Value: 10})
+	rates.Add(Counter{Name: "misses_per_second", Value: 10})
+	rates.Add(Counter{Name: "calls_per_second", Tags: tags, Value: 100})
+	rates.Add(Counter{Name: "calls_per_second", Value: 1000})
+	rates.Add(Counter{Name: "cpu_usage", Value: 0, Scale: 100})
+	if metrics := rates.Rates(start); len(metrics) != 0 {
+		t.Errorf("first collection returned rates %v, want none", metrics)
+	}
+	if _, ok := rates.Ratio("hits_per_second", "misses_per_second"); ok {
+		t.Error("first collection returned a ratio")
+	}
+
+	rates.Add(Counter{Name: "hits_per_second", Value: 40})
+	rates.Add(Counter{Name: "misses_per_second", Value: 20})
+	rates.Add(Counter{Name: "calls_per_second", Tags: tags, Value: 50})
+	rates.Add(Counter{Name: "calls_per_second", Value: 1020})
+	rates.Add(Counter{Name: "cpu_usage", Value: 1, Scale: 100})
+	rates.Add(Counter{Name: "new_per_second", Value: 5})
+	metrics := rates.Rates(start.Add(10 * time.Second))
+
+	// the tagged calls went backwards and new_per_second has nothing to compare against
+	want := map[string]float64{
+		"hits_per_second":   3,
+		"misses_per_second": 1,
+		"calls_per_second":  2,
+		"cpu_usage":         10,
+	}
+	if len(metrics) != len(want) {
+		t.Errorf("got %d rates %v, want %d", len(metrics), metrics, len(want))
+	}
+	for _, metric := range metrics {
+		if metric.Type != RateMetric {
+			t.Errorf("%s has type %v, want a rate", metric.Name, metric.Type)
+		}
+		if len(metric.Tags) != 0 {
+			t.Errorf("%s has tags %v, want the untagged counter", metric.Name, metric.Tags)
+		}
+		if value, ok := want[metric.Name]; !ok || metric.Value != value {
+			t.Errorf("%s = %v, want %v", metric.Name, metric.Value, value)
+		}
+	}
+
+	ratio, ok := rates.Ratio("hits_per_second", "misses_per_second")
+	if !ok || ratio != 0.75 {
+		t.Errorf("Ratio() = %v, %v, want 0.75, true", ratio, ok)
+	}
+	if _, ok := rates.Ratio("hits_per_second", "unknown_per_second"); ok {
+		t.Error("Ratio() of an unknown counter returned a ratio")
+	}
+
+	// the tagged calls restart from the value they went back to
+	rates.Add(Counter{Name: "calls_per_second", Tags: tags, Value: 60})
+	metrics = rates.Rates(start.Add(20 * time.Second))
+	if len(metrics) != 1 || metrics[0].Value != 1 || metrics[0].Tags["command"] != "get" {
+		t.Errorf("got rates %v, want calls_per_second of 1 tagged with the command", metrics)
+	}
+}
@@ -5,14 +5,31 @@ import (
 	"io/ioutil"
 	"log"
 	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 )
 
 type Modules struct {
 	InputModules      []Module
 	TransformModules  []Module
+	TransformInputs   [][]string
 	OutputModules     []Module
-	InputResponseChan chan *ModuleMetrics
+	InputResponseChan chan *InputResponse
 	InputChannels     []chan int
+	InputNames        []string
+	InputIntervals    []time.Duration
+	InputTimeouts     []time.Duration
+	TickInterval      time.Duration
+	inputNextRun      []time.Time
+	inputPending      []bool
+	inputTimeoutCount []float64
+	ModuleConfigs     []ModuleConfig
+	Statuses          []*ModuleStatus
+	statusIndex       map[Module]*ModuleStatus
+	latestLock        sync.RWMutex
+	latestMetrics     map[string]*ModuleMetrics
+	lastTick          time.Time
 }
 
 type Module interface {
@@ -21,27 +38,47 @@ type Module interface {
 	TearDown() error
 }
 
+// TransformModule is handed the metrics collected from its input modules each tick, and returns the
+// metrics that should be sent to the output modules in their place.
 type TransformModule interface {
-	TransformMetrics(metrics []Metric) ([]Metric, error)
+	TransformMetrics(metrics []*ModuleMetrics) ([]*ModuleMetrics, error)
 }
 
 type InputModule interface {
 	GetMetrics() (*ModuleMetrics, error)
 }
 
+// InputResponse is sent by an input module goroutine after every request, with either the metrics
+// or the error from the module.  Index is the position of the module in Modules.InputModules.
+type InputResponse struct {
+	Index   int
+	Metrics *ModuleMetrics
+	Err     error
+}
+
 type OutputModule interface {
 	SendMetrics([]*ModuleMetrics) error
 }
 
-func getModules(config Config) Modules {
+// FlushOutputModule is implemented by output modules that queue metrics they couldn't send, so they
+// can make a last attempt to send them before the daemon exits.  Flush must return by the deadline.
+type FlushOutputModule interface {
+	Flush(deadline time.Time) error
+}
+
+func getModules(config Config) *Modules {
 	files, err := ioutil.ReadDir(config.ConfigPath)
 	if err != nil {
 		log.Fatalf("Problem loading modules: %v", err)
 	}
 
-	modules := Modules{}
+	modules := &Modules{}
+	modules.statusIndex = make(map[Module]*ModuleStatus)
+	moduleIDs := StringSet{}
+	modules.latestMetrics = make(map[string]*ModuleMetrics)
+	modules.TickInterval = secondsToDuration(config.Interval)
 
-	modules.InputResponseChan = make(chan *ModuleMetrics, len(files)*2)
+	modules.InputResponseChan = make(chan *InputResponse, len(files)*2)
 
 	for _, file := range files {
 		if file.IsDir() {
@@ -60,95 +97,211 @@ func getModules(config Config) Modules {
 		moduleConfig := parseModuleConfig(fullPath)
 
 		if moduleConfig.Enabled {
-			module := getModule(moduleConfig.Name)
+			module, err := getModule(moduleConfig.Name)
+			if err != nil {
+				log.Fatalf("Unable to load %s: %v", fullPath, err)
+			}
+			id := moduleConfig.ID()
 
-			log.Printf("Module %s enabled: %v", moduleConfig.Name, moduleConfig)
+			if moduleIDs.Contains(id) {
+				log.Fatalf("Module %s is configured more than once, set a unique instance for each one", id)
+			}
+			moduleIDs.Add(id)
+
+			log.Printf("Module %s enabled: %v", id, moduleConfig)
 
 			switch v := module.(type) {
 			default:
 				log.Fatalf("unexpected type %T", v)
 			case InputModule:
+				status := modules.addStatus(module, id, InputModuleKind)
+				index := len(modules.InputModules)
 				modules.InputModules = append(modules.InputModules, module)
-				requestChan, err := InitInputModule(module, modules.InputResponseChan)
+				modules.InputNames = append(modules.InputNames, id)
+				requestChan, err := InitInputModule(module, index, moduleConfig.Instance, status, modules.InputResponseChan)
 				if err != nil {
 					log.Fatalf("Failed to initialize input module: %v", err)
 				}
 				modules.InputChannels = append(modules.InputChannels, requestChan)
+
+				// modules collect at the global interval unless they specify their own
+				interval := secondsToDuration(config.Interval)
+				if moduleConfig.Interval > 0 {
+					interval = secondsToDuration(moduleConfig.Interval)
+				}
+				modules.InputIntervals = append(modules.InputIntervals, interval)
+				modules.inputNextRun = append(modules.inputNextRun, time.Time{})
+				modules.inputPending = append(modules.inputPending, false)
+				modules.inputTimeoutCount = append(modules.inputTimeoutCount, 0)
+				modules.TickInterval = gcdDuration(modules.TickInterval, interval)
+
+				timeout := secondsToDuration(config.CollectionTimeout)
+				if moduleConfig.Timeout > 0 {
+					timeout = secondsToDuration(moduleConfig.Timeout)
+				}
+				modules.InputTimeouts = append(modules.InputTimeouts, timeout)
 			case TransformModule:
+				modules.addStatus(module, id, TransformModuleKind)
 				modules.TransformModules = append(modules.TransformModules, module)
+				modules.TransformInputs = append(modules.TransformInputs, moduleConfig.Inputs)
 			case OutputModule:
+				modules.addStatus(module, id, OutputModuleKind)
 				modules.OutputModules = append(modules.OutputModules, module)
 			}
+			modules.ModuleConfigs = append(modules.ModuleConfigs, moduleConfig)
 
-			module.Init(&config, &moduleConfig)
+			err = module.Init(&config, &moduleConfig)
+			if err != nil {
+				log.Fatalf("Failed to initialize module %s: %v", id, err)
+			}
 		}
 	}
 
+	if modules.TickInterval < time.Millisecond {
+		log.Fatalf("Invalid interval: %v", modules.TickInterval)
+	}
+
+	modules.clampTimeouts()
+
 	return modules
 }
 
+// clampTimeouts sets the timeouts of the input modules.  A tick waits for an input module for half of
+// the module's interval by default, and never longer than most of its interval so the module responds
+// before it's due again.
+func (modules *Modules) clampTimeouts() {
+	for i, timeout := range modules.InputTimeouts {
+		interval := modules.InputIntervals[i]
+		maxTimeout := interval * 9 / 10
+		if timeout <= 0 {
+			modules.InputTimeouts[i] = interval / 2
+		} else if timeout > maxTimeout {
+			log.Printf("The %s input module timeout %v is longer than its %v interval allows, using %v",
+				modules.InputNames[i], timeout, interval, maxTimeout)
+			modules.InputTimeouts[i] = maxTimeout
+		}
+	}
+}
+
 // InitInputModule creates a channel for making requests on and aggregates the response on the
-// responseChan that is passed in.  It returns the request channel.
-func InitInputModule(module Module, responseChan chan *ModuleMetrics) (chan int, error) {
+// responseChan that is passed in.  Each request is recorded in the module status and answered with a
+// response carrying the module index, and the metrics are labeled with the module instance.  It
+// returns the request channel.
+func InitInputModule(module Module, index int, instance string, status *ModuleStatus, responseChan chan *InputResponse) (chan int, error) {
 	inputModule, ok := module.(InputModule)
 	if !ok {
 		return nil, errors.New("Not an input module")
 	}
-	requestChan := make(chan int)
+	// buffered so a request never waits on a module that is just finishing its previous response
+	requestChan := make(chan int, 1)
 
 	// Create a goroutine to listen for requests on the request channel
-	go func(module InputModule, moduleName string, requestChan chan int, responseChan chan *ModuleMetrics) {
+	go func(module InputModule, moduleName string, requestChan chan int, responseChan chan *InputResponse) {
 		for _ = range requestChan {
+			start := time.Now()
 			metrics, err := module.GetMetrics()
+			status.Record(start, err)
 			if err != nil {
 				log.Printf("Failed to retrieve %s metrics: %v", moduleName, err)
-			} else {
-				responseChan <- metrics
+				metrics = nil
+			} else if metrics != nil {
+				metrics.Instance = instance
 			}
+			responseChan <- &InputResponse{Index: index, Metrics: metrics, Err: err}
 		}
-	}(inputModule, module.Name(), requestChan, responseChan)
+	}(inputModule, moduleID(module.Name(), instance), requestChan, responseChan)
 
 	return requestChan, nil
 }
 
-func getModule(name string) Module {
-	switch name {
-	case CpuModuleName:
-		return &CPUInputModule{}
-	case MemoryModuleName:
-		return &MemoryInputModule{}
-	case NetworkModuleName:
-		return &NetworkInputModule{}
-	case GraphiteModuleName:
-		return &GraphiteOutputModule{}
-	case DiskspaceModuleName:
-		return &DiskspaceInputModule{}
-	case DiskusageModuleName:
-		return &DiskusageInputModule{}
-	case LoadModuleName:
-		return &LoadInputModule{}
-	case ProcessessModuleName:
-		return &ProcessesInputModule{}
-	case InternalModuleName:
-		return &InternalInputModule{}
-	case RedisModuleName:
-		return &RedisInputModule{}
-	default:
-		log.Fatalf("Invalid module: %s", name)
+// inputDue reports whether the input module at index i should be collected on the tick at now, and
+// schedules its next collection if it is.
+func (modules *Modules) inputDue(i int, now time.Time) bool {
+	next := modules.inputNextRun[i]
+
+	// allow for ticks arriving slightly before the module is due
+	if now.Add(modules.TickInterval / 2).Before(next) {
+		return false
+	}
+
+	interval := modules.InputIntervals[i]
+	next = next.Add(interval)
+	if next.Before(now) {
+		next = now.Add(interval)
+	}
+	modules.inputNextRun[i] = next
+
+	return true
+}
+
+// gcdDuration returns the greatest common divisor of two intervals to the millisecond, which is used
+// as the tick interval so that every module interval is a multiple of it.
+func gcdDuration(a time.Duration, b time.Duration) time.Duration {
+	x := int64(a / time.Millisecond)
+	y := int64(b / time.Millisecond)
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return time.Duration(x) * time.Millisecond
+}
+
+func (modules *Modules) addStatus(module Module, id string, kind string) *ModuleStatus {
+	status := NewModuleStatus(id, kind)
+	modules.Statuses = append(modules.Statuses, status)
+	modules.statusIndex[module] = status
+	return status
+}
+
+// Status returns the status tracker for a module
+func (modules *Modules) Status(module Module) *ModuleStatus {
+	return modules.statusIndex[module]
+}
+
+// SetLatestMetrics saves the metrics sent to the output modules during a tick.  Metrics are kept per
+// input module, so a module that wasn't collected this tick keeps its previous metrics.
+func (modules *Modules) SetLatestMetrics(allMetrics []*ModuleMetrics) {
+	modules.latestLock.Lock()
+	defer modules.latestLock.Unlock()
+
+	for _, metrics := range allMetrics {
+		modules.latestMetrics[metrics.ID()] = metrics
 	}
+	modules.lastTick = time.Now()
+}
+
+// LatestMetrics returns the most recent metrics of every input module, sorted by module id, and the
+// time of the last tick.
+func (modules *Modules) LatestMetrics() ([]*ModuleMetrics, time.Time) {
+	modules.latestLock.RLock()
+	defer modules.latestLock.RUnlock()
 
-	return nil
+	names := make([]string, 0, len(modules.latestMetrics))
+	for name := range modules.latestMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	allMetrics := make([]*ModuleMetrics, 0, len(names))
+	for _, name := range names {
+		allMetrics = append(allMetrics, modules.latestMetrics[name])
+	}
+
+	return allMetrics, modules.lastTick
 }
 
 func tearDownModules(modules *Modules) {
-	//close all channels
+	//close the request channels, the response channel is left open since input modules that are
+	//still collecting will send on it when they finish
 	for _, c := range modules.InputChannels {
 		close(c)
 	}
-	close(modules.InputResponseChan)
 
-	// input modules
-	for _, e := range modules.InputModules {
+	// input modules, except those still collecting since they may be using what TearDown releases
+	for i, e := range modules.InputModules {
+		if modules.inputPending[i] {
+			log.Printf("The %s input module is still collecting, skipping its teardown", modules.InputNames[i])
+			continue
+		}
 		e.TearDown()
 	}
 
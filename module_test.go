@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClampTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		timeout  time.Duration
+		expected time.Duration
+	}{
+		{"default is half the interval", 60 * time.Second, 0, 30 * time.Second},
+		{"configured timeout is kept", 60 * time.Second, 20 * time.Second, 20 * time.Second},
+		{"longer than the tick interval but within the module interval", 60 * time.Second, 50 * time.Second, 50 * time.Second},
+		{"clamped to the module interval", 60 * time.Second, 60 * time.Second, 54 * time.Second},
+		{"clamped on a short interval", 10 * time.Second, 30 * time.Second, 9 * time.Second},
+	}
+
+	modules := &Modules{TickInterval: 10 * time.Second}
+	for _, test := range tests {
+		modules.InputNames = append(modules.InputNames, test.name)
+		modules.InputIntervals = append(modules.InputIntervals, test.interval)
+		modules.InputTimeouts = append(modules.InputTimeouts, test.timeout)
+	}
+
+	modules.clampTimeouts()
+
+	for i, test := range tests {
+		if modules.InputTimeouts[i] != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, modules.InputTimeouts[i])
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"io/ioutil"
 	"strconv"
 	"strings"
@@ -9,7 +8,12 @@ import (
 
 const NetworkModuleName = "network"
 
+func init() {
+	RegisterModule(NetworkModuleName, func() Module { return &NetworkInputModule{} })
+}
+
 type NetworkInputModule struct {
+	devPath        string
 	previousIfaces map[string]map[string]float64
 }
 
@@ -18,6 +22,7 @@ func (m *NetworkInputModule) Name() string {
 }
 
 func (m *NetworkInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	m.devPath = config.HostProcSelf("net", "dev")
 	return nil
 }
 
@@ -28,7 +33,7 @@ func (m *NetworkInputModule) TearDown() error {
 func (m *NetworkInputModule) GetMetrics() (*ModuleMetrics, error) {
 	metrics := make([]Metric, 0, 48)
 
-	ifaces, err := ParseNetworkDev("/proc/net/dev")
+	ifaces, err := ParseNetworkDev(m.devPath)
 	if err != nil {
 		return nil, err
 	}
@@ -38,8 +43,9 @@ func (m *NetworkInputModule) GetMetrics() (*ModuleMetrics, error) {
 			previous, ok := m.previousIfaces[iface]
 
 			if ok {
+				tags := map[string]string{"interface": iface}
 				for name, value := range fields {
-					metric := NewMetric(fmt.Sprintf("%s.%s", iface, name), value-previous[name])
+					metric := NewRateMetric(name, value-previous[name], tags)
 					metrics = append(metrics, metric)
 				}
 			}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// pickle opcodes, see Python's Lib/pickletools.py
+const (
+	pickleProto      = 0x80
+	pickleStop       = '.'
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleTuple2     = 0x86
+	pickleBinInt     = 'J'
+	pickleBinFloat   = 'G'
+	pickleBinUnicode = 'X'
+)
+
+// graphitePickle encodes plaintext graphite lines, "path value timestamp\n", as a carbon pickle
+// message: a 4 byte big endian length followed by a pickled list of (path, (timestamp, value)) tuples.
+// Lines that can't be parsed are logged and left out.
+func graphitePickle(lines []string) []byte {
+	var payload bytes.Buffer
+
+	payload.Write([]byte{pickleProto, 2, pickleEmptyList, pickleMark})
+
+	metrics, invalid := parseGraphitePlaintext(strings.Join(lines, ""), time.Now())
+	if invalid > 0 {
+		log.Printf("Skipping %d invalid graphite lines", invalid)
+	}
+
+	for _, metric := range metrics {
+		timestamp := metric.Timestamp.Unix()
+
+		payload.WriteByte(pickleBinUnicode)
+		binary.Write(&payload, binary.LittleEndian, uint32(len(metric.Name)))
+		payload.WriteString(metric.Name)
+
+		if timestamp >= math.MinInt32 && timestamp <= math.MaxInt32 {
+			payload.WriteByte(pickleBinInt)
+			binary.Write(&payload, binary.LittleEndian, int32(timestamp))
+		} else {
+			payload.WriteByte(pickleBinFloat)
+			binary.Write(&payload, binary.BigEndian, float64(timestamp))
+		}
+
+		payload.WriteByte(pickleBinFloat)
+		binary.Write(&payload, binary.BigEndian, metric.Value)
+
+		payload.WriteByte(pickleTuple2)
+		payload.WriteByte(pickleTuple2)
+	}
+
+	payload.Write([]byte{pickleAppends, pickleStop})
+
+	message := make([]byte, 4, 4+payload.Len())
+	binary.BigEndian.PutUint32(message, uint32(payload.Len()))
+
+	return append(message, payload.Bytes()...)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// the expected messages were checked with Python's pickle.loads, which is what carbon uses to read them
+func TestGraphitePickle(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		hex   string
+	}{
+		{
+			name:  "one metric",
+			lines: []string{"servers.web1.cpu 1.5 1700000000\n"},
+			hex: "0000002b" + // length of the pickle
+				"8002" + // PROTO 2
+				"5d28" + // EMPTY_LIST MARK
+				"5810000000" + hex.EncodeToString([]byte("servers.web1.cpu")) + // BINUNICODE path
+				"4a00f15365" + // BININT timestamp, little endian
+				"473ff8000000000000" + // BINFLOAT value, big endian
+				"8686" + // TUPLE2 TUPLE2
+				"652e", // APPENDS STOP
+		},
+		{
+			name:  "invalid lines are left out",
+			lines: []string{"a 1 1700000000\n", "not a metric line\n", "b 2 1700000000\n"},
+			hex: "00000032" +
+				"80025d28" +
+				"5801000000" + "61" + "4a00f15365" + "473ff0000000000000" + "8686" + // ("a", (1700000000, 1.0))
+				"5801000000" + "62" + "4a00f15365" + "474000000000000000" + "8686" +
+				"652e",
+		},
+		{
+			name:  "timestamps past 2038",
+			lines: []string{"a 1 4102444800\n"},
+			hex: "00000020" +
+				"80025d28" +
+				"5801000000" + "61" +
+				"4741ee90cae0000000" + // BINFLOAT timestamp
+				"473ff0000000000000" +
+				"8686652e",
+		},
+		{
+			name:  "no metrics",
+			lines: nil,
+			hex:   "00000006" + "80025d28652e",
+		},
+	}
+
+	for _, test := range tests {
+		want, err := hex.DecodeString(test.hex)
+		if err != nil {
+			t.Fatalf("%s: invalid hex: %v", test.name, err)
+		}
+
+		if got := graphitePickle(test.lines); !bytes.Equal(got, want) {
+			t.Errorf("%s: got\n%x\nwant\n%x", test.name, got, want)
+		}
+	}
+}
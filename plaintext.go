@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseGraphitePlaintext parses lines in the graphite plaintext protocol, name value [timestamp], as
+// written by the graphite output and by exec commands.  Lines without a timestamp are given now.  It
+// returns the number of lines that couldn't be parsed along with the metrics of the rest.
+func parseGraphitePlaintext(text string, now time.Time) ([]Metric, int) {
+	metrics := make([]Metric, 0, 8)
+	invalid := 0
+
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			invalid++
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			invalid++
+			continue
+		}
+
+		metric := NewMetric(fields[0], value)
+		metric.Timestamp = now
+		if len(fields) == 3 {
+			timestamp, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				invalid++
+				continue
+			}
+			metric.Timestamp = time.Unix(int64(timestamp), 0)
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, invalid
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const PressureModuleName = "pressure"
+
+func init() {
+	RegisterModule(PressureModuleName, func() Module { return &PressureInputModule{} },
+		ModuleSetting{"resources", "resources to report, defaults to cpu, memory, io and irq"},
+		ModuleSetting{"cgroups", "cgroup v2 paths, relative to the cgroup root and allowing globs, to report the pressure of"},
+	)
+}
+
+var defaultPressureResources = []string{"cpu", "memory", "io", "irq"}
+
+// PressureStall is one line of a pressure file, the share of time some or all tasks were stalled
+type PressureStall struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	// Total is the cumulative stall time in microseconds
+	Total float64
+}
+
+type PressureInputModule struct {
+	Resources  []string
+	Cgroups    []string
+	procPath   string
+	cgroupPath string
+	supported  bool
+	// system pressure files that couldn't be read, they are logged once and not tried again
+	unavailable StringSet
+	rates       CounterRates
+}
+
+func (m *PressureInputModule) Name() string {
+	return PressureModuleName
+}
+
+func (m *PressureInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	resources, err := moduleConfig.SettingsStringArray("resources")
+	if err != nil || len(resources) == 0 {
+		resources = defaultPressureResources
+	}
+
+	cgroups, _ := moduleConfig.SettingsStringArray("cgroups")
+	for _, cgroup := range cgroups {
+		if _, err := filepath.Match(cgroup, ""); err != nil {
+			log.Fatalf("Unable to parse cgroup %s: %v", cgroup, err)
+		}
+	}
+
+	m.Resources = resources
+	m.Cgroups = cgroups
+	m.procPath = config.HostProc("pressure")
+	m.cgroupPath = config.HostSys("fs", "cgroup")
+
+	// kernels before 4.20, or booted with psi=0, don't have /proc/pressure
+	_, err = os.Stat(m.procPath)
+	m.supported = err == nil
+	if !m.supported {
+		log.Printf("Pressure stall information isn't supported by this kernel, the pressure module won't report metrics: %v", err)
+	}
+
+	return nil
+}
+
+func (m *PressureInputModule) TearDown() error {
+	return nil
+}
+
+func (m *PressureInputModule) GetMetrics() (*ModuleMetrics, error) {
+	now := time.Now()
+	metrics := make([]Metric, 0, 48)
+
+	if !m.supported {
+		return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+	}
+
+	for _, resource := range m.Resources {
+		path := filepath.Join(m.procPath, resource)
+		if m.unavailable.Contains(path) {
+			continue
+		}
+
+		stalls, err := ParsePressure(path)
+		if err != nil {
+			log.Printf("Pressure stall information isn't available from %s, it won't be reported: %v", path, err)
+			m.unavailable.Add(path)
+			continue
+		}
+
+		tags := map[string]string{"resource": resource}
+		metrics = append(metrics, m.pressureMetrics(stalls, tags)...)
+	}
+
+	for _, cgroup := range m.cgroupDirs() {
+		for _, resource := range m.Resources {
+			// cgroups come and go, and not every controller is enabled in every cgroup, so files that
+			// can't be read are skipped quietly
+			stalls, err := ParsePressure(filepath.Join(m.cgroupPath, cgroup, resource+".pressure"))
+			if err != nil {
+				continue
+			}
+
+			tags := map[string]string{"resource": resource, "cgroup": cgroup}
+			metrics = append(metrics, m.pressureMetrics(stalls, tags)...)
+		}
+	}
+
+	metrics = append(metrics, m.rates.Rates(now)...)
+
+	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+}
+
+// cgroupDirs expands the configured cgroups into the cgroup directories that currently exist,
+// relative to the cgroup root
+func (m *PressureInputModule) cgroupDirs() []string {
+	dirs := make([]string, 0, len(m.Cgroups))
+	seen := StringSet{}
+
+	for _, cgroup := range m.Cgroups {
+		matches, err := filepath.Glob(filepath.Join(m.cgroupPath, cgroup))
+		if err != nil {
+			continue
+		}
+
+		for _, match := range matches {
+			dir, err := filepath.Rel(m.cgroupPath, match)
+			if err != nil || seen.Contains(dir) {
+				continue
+			}
+			seen.Add(dir)
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// pressureMetrics returns the averages of each stall line, and records its total for the rates
+func (m *PressureInputModule) pressureMetrics(stalls map[string]PressureStall, tags map[string]string) []Metric {
+	metrics := make([]Metric, 0, 6)
+
+	for kind, stall := range stalls {
+		metrics = append(metrics, NewTaggedMetric(kind+"_avg10", stall.Avg10, tags))
+		metrics = append(metrics, NewTaggedMetric(kind+"_avg60", stall.Avg60, tags))
+		metrics = append(metrics, NewTaggedMetric(kind+"_avg300", stall.Avg300, tags))
+
+		m.rates.Add(Counter{Name: kind + "_total_per_second", Tags: tags, Value: stall.Total})
+	}
+
+	return metrics
+}
+
+// ParsePressure parses a pressure file into its some and full lines, e.g.
+// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func ParsePressure(path string) (map[string]PressureStall, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stalls := make(map[string]PressureStall)
+
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		stall := PressureStall{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				log.Printf("Error parsing %s as float64: %v", kv[1], err)
+				continue
+			}
+
+			switch kv[0] {
+			case "avg10":
+				stall.Avg10 = value
+			case "avg60":
+				stall.Avg60 = value
+			case "avg300":
+				stall.Avg300 = value
+			case "total":
+				stall.Total = value
+			}
+		}
+
+		stalls[fields[0]] = stall
+	}
+
+	if len(stalls) == 0 {
+		return nil, errors.New("no pressure stall information found")
+	}
+
+	return stalls, nil
+}
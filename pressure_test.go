@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeFixture writes a file of a fixture tree, creating its directories
+func writeFixture(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testPressure = `some avg10=1.50 avg60=0.75 avg300=0.25 total=1000000
+full avg10=0.50 avg60=0.25 avg300=0.10 total=400000
+`
+
+func TestParsePressure(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		stalls  map[string]PressureStall
+	}{
+		{"some and full", testPressure, map[string]PressureStall{
+			"some": {Avg10: 1.5, Avg60: 0.75, Avg300: 0.25, Total: 1000000},
+			"full": {Avg10: 0.5, Avg60: 0.25, Avg300: 0.1, Total: 400000},
+		}},
+		{"some only", "some avg10=0.00 avg60=0.00 avg300=0.00 total=12\n", map[string]PressureStall{
+			"some": {Total: 12},
+		}},
+		{"empty", "", nil},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		writeFixture(t, path, test.content)
+
+		stalls, err := ParsePressure(path)
+		if test.stalls == nil {
+			if err == nil {
+				t.Errorf("%s: ParsePressure() = %v, want an error", test.name, stalls)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(stalls, test.stalls) {
+			t.Errorf("%s: ParsePressure() = %v, %v, want %v", test.name, stalls, err, test.stalls)
+		}
+	}
+
+	if _, err := ParsePressure(filepath.Join(dir, "missing")); err == nil {
+		t.Error("ParsePressure() of a missing file didn't return an error")
+	}
+}
+
+func initPressure(t *testing.T, config *Config, settings map[string]interface{}) *PressureInputModule {
+	m := &PressureInputModule{}
+	if err := m.Init(config, &ModuleConfig{Name: PressureModuleName, Settings: settings}); err != nil {
+		t.Fatalf("Init() returned %v", err)
+	}
+	return m
+}
+
+func TestPressureUnsupported(t *testing.T) {
+	m := initPressure(t, &Config{ProcPath: t.TempDir(), SysPath: t.TempDir()}, map[string]interface{}{})
+	if m.supported {
+		t.Fatal("a kernel without a pressure directory is supported")
+	}
+
+	moduleMetrics, err := m.GetMetrics()
+	if err != nil || len(moduleMetrics.Metrics) != 0 {
+		t.Errorf("GetMetrics() = %v, %v, want no metrics", moduleMetrics.Metrics, err)
+	}
+}
+
+func TestPressureUnavailableResources(t *testing.T) {
+	procPath := t.TempDir()
+	writeFixture(t, filepath.Join(procPath, "pressure", "cpu"), testPressure)
+	writeFixture(t, filepath.Join(procPath, "pressure", "memory"), testPressure)
+
+	m := initPressure(t, &Config{ProcPath: procPath, SysPath: t.TempDir()}, map[string]interface{}{})
+
+	for i := 0; i < 2; i++ {
+		moduleMetrics, err := m.GetMetrics()
+		if err != nil {
+			t.Fatalf("GetMetrics() returned %v", err)
+		}
+		resources := StringSet{}
+		for _, metric := range moduleMetrics.Metrics {
+			resources.Add(metric.Tags["resource"])
+		}
+		if !resources.Contains("cpu") || !resources.Contains("memory") || resources.Contains("io") {
+			t.Errorf("collection %d reported %v, want only cpu and memory", i, moduleMetrics.Metrics)
+		}
+
+		// a file missing at the first collection isn't looked for again
+		writeFixture(t, filepath.Join(procPath, "pressure", "io"), testPressure)
+	}
+
+	for _, resource := range []string{"io", "irq"} {
+		if !m.unavailable.Contains(filepath.Join(procPath, "pressure", resource)) {
+			t.Errorf("%s isn't marked unavailable", resource)
+		}
+	}
+}
+
+func TestPressureCgroups(t *testing.T) {
+	procPath := t.TempDir()
+	sysPath := t.TempDir()
+	writeFixture(t, filepath.Join(procPath, "pressure", "cpu"), testPressure)
+
+	cgroupPath := filepath.Join(sysPath, "fs", "cgroup")
+	for _, cgroup := range []string{"system.slice/a.service", "system.slice/b.service", "user.slice"} {
+		writeFixture(t, filepath.Join(cgroupPath, cgroup, "cpu.pressure"), testPressure)
+	}
+	// the memory controller isn't enabled in user.slice
+	writeFixture(t, filepath.Join(cgroupPath, "system.slice/a.service", "memory.pressure"), testPressure)
+	writeFixture(t, filepath.Join(cgroupPath, "system.slice/b.service", "memory.pressure"), testPressure)
+	writeFixture(t, filepath.Join(cgroupPath, "system.slice/c.scope", "cpu.pressure"), testPressure)
+
+	m := initPressure(t, &Config{ProcPath: procPath, SysPath: sysPath}, map[string]interface{}{
+		"resources": []interface{}{"cpu", "memory"},
+		"cgroups":   []interface{}{"system.slice/*.service", "user.slice", "system.slice/a.service", "missing.slice"},
+	})
+
+	moduleMetrics, err := m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+
+	// each pressure file reports the three averages of its some and full lines
+	counts := make(map[string]int)
+	for _, metric := range moduleMetrics.Metrics {
+		counts[metric.Tags["cgroup"]+" "+metric.Tags["resource"]]++
+	}
+	want := map[string]int{
+		" cpu":                          6,
+		"system.slice/a.service cpu":    6,
+		"system.slice/a.service memory": 6,
+		"system.slice/b.service cpu":    6,
+		"system.slice/b.service memory": 6,
+		"user.slice cpu":                6,
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("got metrics per cgroup and resource %v, want %v", counts, want)
+	}
+
+	// a second collection ten seconds later reports the microseconds stalled per second
+	writeFixture(t, filepath.Join(cgroupPath, "user.slice", "cpu.pressure"),
+		"some avg10=1.50 avg60=0.75 avg300=0.25 total=1500000\nfull avg10=0.50 avg60=0.25 avg300=0.10 total=400000\n")
+	m.rates.previousTime = m.rates.previousTime.Add(-10 * time.Second)
+
+	moduleMetrics, err = m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+	rates := 0
+	for _, metric := range moduleMetrics.Metrics {
+		if metric.Type != RateMetric {
+			continue
+		}
+		rates++
+		if metric.Tags["cgroup"] == "user.slice" && metric.Name == "some_total_per_second" {
+			if metric.Value < 49000 || metric.Value > 50000 {
+				t.Errorf("user.slice some_total_per_second = %v, want about 50000", metric.Value)
+			}
+		} else if metric.Value != 0 {
+			t.Errorf("%s %v = %v, want 0", metric.Name, metric.Tags, metric.Value)
+		}
+	}
+	if rates != 12 {
+		t.Errorf("got %d rates, want some and full for each of the 6 pressure files", rates)
+	}
+}
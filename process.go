@@ -2,13 +2,17 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"log"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+// utime and stime are counted in clock ticks, which are 100 per second on linux
+const processClockTicks = 100.0
+
+// Process is parsed from /proc/<pid>/stat, Name is the comm of the process without its parentheses
 type Process struct {
 	Pid    int64
 	Name   string
@@ -64,14 +68,22 @@ var processStatFields = [...]string{
 	"cguest_time",           // Guest time of the process's children
 }
 
-func GetProcessStats(pid int64) (*Process, error) {
-	path := fmt.Sprintf("/proc/%d/stat", pid)
+// GetProcessStats reads /proc/<pid>/stat from the given proc directory
+func GetProcessStats(procPath string, pid int64) (*Process, error) {
+	path := filepath.Join(procPath, strconv.FormatInt(pid, 10), "stat")
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	content := string(data)
-	fields := strings.Fields(content)
+
+	// comm can contain spaces and parentheses, so it runs from the first ( to the last )
+	start := strings.Index(content, "(")
+	end := strings.LastIndex(content, ")")
+	if start < 0 || end < start {
+		return nil, errors.New("Invalid stat file")
+	}
+	fields := append([]string{content[:start], content[start+1 : end]}, strings.Fields(content[end+1:])...)
 
 	if len(fields) < 4 {
 		return nil, errors.New("Invalid stat file")
@@ -121,3 +133,57 @@ func GetProcessStats(pid int64) (*Process, error) {
 
 	return &process, nil
 }
+
+// GetProcessCmdline returns the command line of a process with its arguments separated by spaces.  It
+// is empty for kernel threads, or if the process has exited.
+func GetProcessCmdline(procPath string, pid int64) string {
+	b, _ := ioutil.ReadFile(filepath.Join(procPath, strconv.FormatInt(pid, 10), "cmdline"))
+	return strings.TrimSpace(strings.Replace(string(b), "\x00", " ", -1))
+}
+
+// GetProcessUID returns the real uid of a process from its status file
+func GetProcessUID(procPath string, pid int64) string {
+	b, err := ioutil.ReadFile(filepath.Join(procPath, strconv.FormatInt(pid, 10), "status"))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "Uid:" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// GetProcessIO returns the bytes a process has read from and written to storage, from
+// /proc/<pid>/io.  io is only readable by the owner of the process, or root.
+func GetProcessIO(procPath string, pid int64) (float64, float64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(procPath, strconv.FormatInt(pid, 10), "io"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var readBytes, writeBytes float64
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "read_bytes:":
+			readBytes = value
+		case "write_bytes:":
+			writeBytes = value
+		}
+	}
+
+	return readBytes, writeBytes, nil
+}
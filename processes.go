@@ -1,19 +1,133 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"os/user"
+	"sort"
 	"strconv"
+	"time"
 )
 
-const ProcessessModuleName = "processes"
+const ProcessesModuleName = "processes"
 
-type ProcessesInputModule struct{}
+func init() {
+	RegisterModule(ProcessesModuleName, func() Module { return &ProcessesInputModule{} },
+		ModuleSetting{"top", "number of top processes to report for each of top_by, off by default"},
+		ModuleSetting{"top_by", "what to rank the top processes by, any of cpu, rss, and io, defaults to all three"},
+	)
+}
+
+// the dimensions the top processes can be ranked by
+const (
+	TopByCPU = "cpu"
+	TopByRSS = "rss"
+	TopByIO  = "io"
+)
+
+var defaultTopBy = []string{TopByCPU, TopByRSS, TopByIO}
+
+// TopProcess is a process in one of the top rankings, shown in the http api with the module metrics
+type TopProcess struct {
+	Rank    int     `json:"rank"`
+	Pid     int64   `json:"pid"`
+	Comm    string  `json:"comm"`
+	Cmdline string  `json:"cmdline"`
+	User    string  `json:"user"`
+	Value   float64 `json:"value"`
+}
+
+// MarshalJSON encodes the process with a value of null if it isn't finite
+func (p TopProcess) MarshalJSON() ([]byte, error) {
+	type topProcess TopProcess
+	return json.Marshal(struct {
+		topProcess
+		Value jsonFloat `json:"value"`
+	}{topProcess(p), jsonFloat(p.Value)})
+}
+
+// topSample holds the cumulative counters of a process, kept between collections to rank processes by
+// their cpu and io since the last collection
+type topSample struct {
+	CPU   float64
+	IO    float64
+	HasIO bool
+}
+
+// topCandidate is a process that can be ranked, with its value for each dimension.  Key is its pid and
+// start time, which tells a reused pid apart from the process that had it before.
+type topCandidate struct {
+	Process *Process
+	Key     string
+	Values  map[string]float64
+}
+
+// topInfo is the cmdline and user of a top process, only looked up once it makes a ranking and kept
+// while it stays in one
+type topInfo struct {
+	Cmdline string
+	User    string
+}
+
+// topRanking sorts candidates by one dimension, highest first
+type topRanking struct {
+	Candidates []*topCandidate
+	By         string
+}
+
+func (r topRanking) Len() int {
+	return len(r.Candidates)
+}
+
+func (r topRanking) Less(i, j int) bool {
+	return r.Candidates[i].Values[r.By] > r.Candidates[j].Values[r.By]
+}
+
+func (r topRanking) Swap(i, j int) {
+	r.Candidates[i], r.Candidates[j] = r.Candidates[j], r.Candidates[i]
+}
+
+type ProcessesInputModule struct {
+	Top          int
+	TopBy        []string
+	procPath     string
+	pageSize     float64
+	users        map[string]string
+	infos        map[string]topInfo
+	previous     map[string]topSample
+	previousTime time.Time
+}
 
 func (m *ProcessesInputModule) Name() string {
-	return ProcessessModuleName
+	return ProcessesModuleName
 }
 
 func (m *ProcessesInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	m.procPath = config.HostProc()
+	m.pageSize = float64(os.Getpagesize())
+	m.users = make(map[string]string)
+
+	top, err := moduleConfig.SettingsInt("top")
+	if err == nil {
+		if top < 0 {
+			return fmt.Errorf("top must not be negative, got %d", top)
+		}
+		m.Top = top
+	}
+
+	topBy, err := moduleConfig.SettingsStringArray("top_by")
+	if err != nil || len(topBy) == 0 {
+		topBy = defaultTopBy
+	}
+	for _, by := range topBy {
+		if by != TopByCPU && by != TopByRSS && by != TopByIO {
+			return fmt.Errorf("unknown top_by %s, expected cpu, rss, or io", by)
+		}
+	}
+	m.TopBy = topBy
+
 	return nil
 }
 
@@ -23,6 +137,7 @@ func (m *ProcessesInputModule) TearDown() error {
 
 func (m *ProcessesInputModule) GetMetrics() (*ModuleMetrics, error) {
 	metrics := make([]Metric, 0, 48)
+	now := time.Now()
 
 	states := map[string]int{
 		"running":  0,
@@ -33,11 +148,13 @@ func (m *ProcessesInputModule) GetMetrics() (*ModuleMetrics, error) {
 		"paging":   0,
 	}
 
-	files, err := ioutil.ReadDir("/proc")
+	files, err := ioutil.ReadDir(m.procPath)
 	if err != nil {
 		return nil, err
 	}
 
+	candidates := make([]*topCandidate, 0, len(files))
+
 	for _, file := range files {
 		if !file.IsDir() {
 			continue
@@ -48,17 +165,164 @@ func (m *ProcessesInputModule) GetMetrics() (*ModuleMetrics, error) {
 			continue
 		}
 
-		process, err := GetProcessStats(pid)
+		process, err := GetProcessStats(m.procPath, pid)
 		if err != nil {
 			continue
 		}
 
 		states[process.State]++
+
+		if m.Top > 0 {
+			candidates = append(candidates, &topCandidate{Process: process})
+		}
 	}
 
 	for state, total := range states {
 		metrics = append(metrics, NewMetric(state, float64(total)))
 	}
 
-	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+	moduleMetrics := &ModuleMetrics{Module: m.Name(), Metrics: metrics}
+	if m.Top > 0 {
+		top := m.topProcesses(candidates, now)
+		moduleMetrics.Metrics = append(moduleMetrics.Metrics, m.topMetrics(top)...)
+		moduleMetrics.Details = map[string]interface{}{"top": top}
+	}
+
+	return moduleMetrics, nil
+}
+
+// topProcesses ranks the processes by each of TopBy and returns the top of each ranking.  cpu is the
+// percent of a single cpu used and io is the bytes read and written per second, both since the last
+// collection, so processes that started since then aren't ranked by them.  Ranking by io has to read
+// the io of every process, so it's only read when io is one of TopBy.
+func (m *ProcessesInputModule) topProcesses(candidates []*topCandidate, now time.Time) map[string][]TopProcess {
+	rankIO := false
+	for _, by := range m.TopBy {
+		rankIO = rankIO || by == TopByIO
+	}
+
+	elapsed := now.Sub(m.previousTime).Seconds()
+	samples := make(map[string]topSample, len(candidates))
+
+	for _, candidate := range candidates {
+		process := candidate.Process
+		candidate.Values = map[string]float64{TopByRSS: process.Fields["rss"] * m.pageSize}
+
+		sample := topSample{CPU: process.Fields["utime"] + process.Fields["stime"]}
+		if rankIO {
+			readBytes, writeBytes, err := GetProcessIO(m.procPath, process.Pid)
+			sample.IO = readBytes + writeBytes
+			sample.HasIO = err == nil
+		}
+
+		candidate.Key = strconv.FormatInt(process.Pid, 10) + ":" + strconv.FormatFloat(process.Fields["starttime"], 'f', 0, 64)
+		samples[candidate.Key] = sample
+
+		previous, ok := m.previous[candidate.Key]
+		if !ok || elapsed <= 0 {
+			continue
+		}
+
+		candidate.Values[TopByCPU] = (sample.CPU - previous.CPU) / processClockTicks / elapsed * 100
+		if sample.HasIO && previous.HasIO {
+			candidate.Values[TopByIO] = (sample.IO - previous.IO) / elapsed
+		}
+	}
+
+	m.previous = samples
+	m.previousTime = now
+
+	top := make(map[string][]TopProcess, len(m.TopBy))
+	infos := make(map[string]topInfo, len(m.TopBy)*m.Top)
+	for _, by := range m.TopBy {
+		// idle processes aren't worth ranking
+		ranked := make([]*topCandidate, 0, len(candidates))
+		for _, candidate := range candidates {
+			if candidate.Values[by] > 0 {
+				ranked = append(ranked, candidate)
+			}
+		}
+		sort.Sort(topRanking{Candidates: ranked, By: by})
+
+		if len(ranked) > m.Top {
+			ranked = ranked[:m.Top]
+		}
+
+		processes := make([]TopProcess, 0, len(ranked))
+		for i, candidate := range ranked {
+			info := m.topInfo(candidate, infos)
+			processes = append(processes, TopProcess{
+				Rank:    i + 1,
+				Pid:     candidate.Process.Pid,
+				Comm:    candidate.Process.Name,
+				Cmdline: info.Cmdline,
+				User:    info.User,
+				Value:   candidate.Values[by],
+			})
+		}
+		top[by] = processes
+	}
+
+	// processes that dropped out of every ranking are looked up again if they make one later
+	m.infos = infos
+
+	return top
+}
+
+// topInfo returns the cmdline and user of a ranked process, from the last collection if it was ranked
+// then too, and adds it to infos
+func (m *ProcessesInputModule) topInfo(candidate *topCandidate, infos map[string]topInfo) topInfo {
+	if info, ok := infos[candidate.Key]; ok {
+		return info
+	}
+
+	info, ok := m.infos[candidate.Key]
+	if !ok {
+		pid := candidate.Process.Pid
+		info = topInfo{
+			Cmdline: GetProcessCmdline(m.procPath, pid),
+			User:    m.username(GetProcessUID(m.procPath, pid)),
+		}
+	}
+	infos[candidate.Key] = info
+
+	return info
+}
+
+// topMetrics reports each top process as top_cpu, top_rss, or top_io, tagged with its name and rank
+func (m *ProcessesInputModule) topMetrics(top map[string][]TopProcess) []Metric {
+	metrics := make([]Metric, 0, len(top)*m.Top)
+
+	for by, processes := range top {
+		for _, process := range processes {
+			tags := map[string]string{
+				"process": process.Comm,
+				"rank":    strconv.Itoa(process.Rank),
+			}
+
+			metric := NewTaggedMetric("top_"+by, process.Value, tags)
+			if by != TopByRSS {
+				metric.Type = RateMetric
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return metrics
+}
+
+// username returns the name of a uid, or the uid itself if it can't be looked up
+func (m *ProcessesInputModule) username(uid string) string {
+	if name, ok := m.users[uid]; ok {
+		return name
+	}
+
+	name := uid
+	u, err := user.LookupId(uid)
+	if err == nil {
+		name = u.Username
+	}
+	m.users[uid] = name
+
+	return name
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessesInit(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		top      int
+		topBy    []string
+		err      bool
+	}{
+		{"defaults", map[string]interface{}{}, 0, defaultTopBy, false},
+		{"top", map[string]interface{}{"top": 5}, 5, defaultTopBy, false},
+		{"top_by", map[string]interface{}{"top": 3, "top_by": []interface{}{"rss", "io"}}, 3, []string{"rss", "io"}, false},
+		{"negative top", map[string]interface{}{"top": -1}, 0, nil, true},
+		{"unknown top_by", map[string]interface{}{"top": 3, "top_by": []interface{}{"cpu", "memory"}}, 0, nil, true},
+	}
+
+	for _, test := range tests {
+		m := &ProcessesInputModule{}
+		err := m.Init(&Config{}, &ModuleConfig{Name: ProcessesModuleName, Settings: test.settings})
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: Init() didn't return an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Init() returned %v", test.name, err)
+			continue
+		}
+
+		if m.Top != test.top || !reflect.DeepEqual(m.TopBy, test.topBy) {
+			t.Errorf("%s: got top %d by %v, want %d by %v", test.name, m.Top, m.TopBy, test.top, test.topBy)
+		}
+	}
+}
+
+// writeFakeProcess writes the stat, cmdline, and status files of a process under a fake proc path
+func writeFakeProcess(t *testing.T, procPath string, pid int, comm string, cpu int, rss int, cmdline string) {
+	dir := filepath.Join(procPath, strconv.Itoa(pid))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// utime is the cpu, starttime 1000, and rss in pages
+	stat := fmt.Sprintf("%d (%s) S 1 1 1 0 -1 0 0 0 0 0 %d 0 0 0 20 0 1 0 1000 0 %d\n", pid, comm, cpu, rss)
+	files := map[string]string{
+		"stat":    stat,
+		"cmdline": strings.Replace(cmdline, " ", "\x00", -1) + "\x00",
+		"status":  "Name:\t" + comm + "\nUid:\t0\t0\t0\t0\n",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessesTopInfoLookedUpForRankedProcesses(t *testing.T) {
+	procPath, err := ioutil.TempDir("", "processes-top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(procPath)
+
+	writeFakeProcess(t, procPath, 10, "big", 100, 1000, "/usr/bin/big --serve")
+	writeFakeProcess(t, procPath, 20, "busy", 100, 10, "/usr/bin/busy")
+	writeFakeProcess(t, procPath, 30, "idle", 100, 1, "/usr/bin/idle")
+
+	m := &ProcessesInputModule{}
+	err = m.Init(&Config{ProcPath: procPath}, &ModuleConfig{Name: ProcessesModuleName, Settings: map[string]interface{}{
+		"top":    1,
+		"top_by": []interface{}{"cpu", "rss"},
+	}})
+	if err != nil {
+		t.Fatalf("Init() returned %v", err)
+	}
+
+	topOf := func(moduleMetrics *ModuleMetrics, by string) TopProcess {
+		top := moduleMetrics.Details.(map[string]interface{})["top"].(map[string][]TopProcess)
+		if len(top[by]) != 1 {
+			t.Fatalf("got top %s %v, want one process", by, top[by])
+		}
+		return top[by][0]
+	}
+
+	moduleMetrics, err := m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+	big := topOf(moduleMetrics, "rss")
+	if big.Pid != 10 || big.Cmdline != "/usr/bin/big --serve" || big.User == "" {
+		t.Errorf("got top rss %+v, want big with its cmdline and user", big)
+	}
+	if len(m.infos) != 1 {
+		t.Errorf("looked up %d processes, want only the one ranked", len(m.infos))
+	}
+
+	// busy uses the most cpu since the first collection, and big keeps the cmdline it was ranked with
+	writeFakeProcess(t, procPath, 10, "big", 100, 1000, "/usr/bin/big --changed")
+	writeFakeProcess(t, procPath, 20, "busy", 150, 10, "/usr/bin/busy")
+	m.previousTime = m.previousTime.Add(-time.Second)
+
+	moduleMetrics, err = m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+	if big := topOf(moduleMetrics, "rss"); big.Cmdline != "/usr/bin/big --serve" {
+		t.Errorf("big cmdline = %q, want the one it was first ranked with", big.Cmdline)
+	}
+	if busy := topOf(moduleMetrics, "cpu"); busy.Pid != 20 || busy.Cmdline != "/usr/bin/busy" {
+		t.Errorf("got top cpu %+v, want busy with its cmdline", busy)
+	}
+	if _, ok := m.infos["30:1000"]; ok || len(m.infos) != 2 {
+		t.Errorf("got infos %v, want only big and busy", m.infos)
+	}
+}
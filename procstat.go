@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ProcstatModuleName = "procstat"
+
+func init() {
+	RegisterModule(ProcstatModuleName, func() Module { return &ProcstatInputModule{} },
+		ModuleSetting{"groups", "list of process groups, each with a name and any of pidfile, comm, cmdline (a regex), user, and cgroup, which must all match"},
+	)
+}
+
+// ProcessGroup selects the processes reported together by the procstat module.  Every selector that
+// is set has to match.
+type ProcessGroup struct {
+	Name    string
+	PidFile string
+	Comm    string
+	Cmdline *regexp.Regexp
+	// User is the uid, users given by name are looked up at startup
+	User   string
+	Cgroup string
+}
+
+// procstatSample holds the cumulative counters of a process, kept between collections to derive the
+// rates of each group
+type procstatSample struct {
+	UTime      float64
+	STime      float64
+	MinFlt     float64
+	MajFlt     float64
+	ReadBytes  float64
+	WriteBytes float64
+	HasIO      bool
+}
+
+// procstatGroupStats sums up the processes in a group
+type procstatGroupStats struct {
+	Count      float64
+	RSS        float64
+	VSize      float64
+	Threads    float64
+	FDs        float64
+	CPUUser    float64
+	CPUSystem  float64
+	MinFlt     float64
+	MajFlt     float64
+	ReadBytes  float64
+	WriteBytes float64
+}
+
+type ProcstatInputModule struct {
+	Groups       []*ProcessGroup
+	procPath     string
+	rootfsPath   string
+	pageSize     float64
+	previous     map[string]procstatSample
+	previousTime time.Time
+}
+
+func (m *ProcstatInputModule) Name() string {
+	return ProcstatModuleName
+}
+
+func (m *ProcstatInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	groupConfigs, err := moduleConfig.SettingsMapArray("groups")
+	if err != nil {
+		return fmt.Errorf("groups must be specified: %v", err)
+	}
+
+	names := StringSet{}
+	m.Groups = make([]*ProcessGroup, 0, len(groupConfigs))
+
+	for _, groupConfig := range groupConfigs {
+		group := &ProcessGroup{}
+
+		group.Name, err = groupConfig.SettingsString("name")
+		if err != nil || group.Name == "" {
+			return fmt.Errorf("process group name must be specified: %v", err)
+		}
+		if names.Contains(group.Name) {
+			return fmt.Errorf("process group %s is specified more than once", group.Name)
+		}
+		names.Add(group.Name)
+
+		group.PidFile, _ = groupConfig.SettingsString("pidfile")
+		group.Comm, _ = groupConfig.SettingsString("comm")
+		group.Cgroup, _ = groupConfig.SettingsString("cgroup")
+		group.Cgroup = strings.Trim(group.Cgroup, "/")
+
+		cmdline, _ := groupConfig.SettingsString("cmdline")
+		if cmdline != "" {
+			group.Cmdline, err = regexp.Compile(cmdline)
+			if err != nil {
+				return fmt.Errorf("unable to parse cmdline for %s: %v", group.Name, err)
+			}
+		}
+
+		// users can be given by name or uid
+		username, err := groupConfig.SettingsString("user")
+		if err != nil {
+			uid, err := groupConfig.SettingsInt("user")
+			if err == nil {
+				username = strconv.Itoa(uid)
+			}
+		}
+		if username != "" {
+			group.User = username
+			if _, err := strconv.Atoi(username); err != nil {
+				u, err := user.Lookup(username)
+				if err != nil {
+					return fmt.Errorf("unable to find user %s for %s: %v", username, group.Name, err)
+				}
+				group.User = u.Uid
+			}
+		}
+
+		if group.PidFile == "" && group.Comm == "" && group.Cmdline == nil && group.User == "" && group.Cgroup == "" {
+			return fmt.Errorf("process group %s needs at least one of pidfile, comm, cmdline, user, or cgroup", group.Name)
+		}
+
+		m.Groups = append(m.Groups, group)
+	}
+
+	m.procPath = config.HostProc()
+	m.rootfsPath = config.HostRootfs()
+	m.pageSize = float64(os.Getpagesize())
+
+	return nil
+}
+
+func (m *ProcstatInputModule) TearDown() error {
+	return nil
+}
+
+func (m *ProcstatInputModule) GetMetrics() (*ModuleMetrics, error) {
+	now := time.Now()
+
+	files, err := ioutil.ReadDir(m.procPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pidFiles := m.readPidFiles()
+
+	elapsed := now.Sub(m.previousTime).Seconds()
+	samples := make(map[string]procstatSample)
+	stats := make([]procstatGroupStats, len(m.Groups))
+
+	for _, file := range files {
+		pid, err := strconv.ParseInt(file.Name(), 10, 0)
+		if err != nil || !file.IsDir() {
+			continue
+		}
+
+		// processes can exit at any point while they're read, so anything that can't be read is
+		// skipped
+		process, err := GetProcessStats(m.procPath, pid)
+		if err != nil {
+			continue
+		}
+
+		matched := false
+		info := procstatInfo{}
+		for i, group := range m.Groups {
+			if !m.matches(group, process, pidFiles[i], &info) {
+				continue
+			}
+
+			if !matched {
+				matched = true
+				sample := m.sample(process)
+				// the start time tells a reused pid apart from the process that had it before
+				key := file.Name() + ":" + strconv.FormatFloat(process.Fields["starttime"], 'f', 0, 64)
+				samples[key] = sample
+				info.sample = sample
+				info.previous, info.hasPrevious = m.previous[key]
+				info.fds = m.countFDs(pid)
+			}
+
+			m.addProcess(&stats[i], process, &info)
+		}
+	}
+
+	metrics := make([]Metric, 0, len(m.Groups)*12)
+	for i, group := range m.Groups {
+		tags := map[string]string{"group": group.Name}
+		s := stats[i]
+
+		metrics = append(metrics, NewTaggedMetric("count", s.Count, tags))
+		metrics = append(metrics, NewTaggedMetric("rss", s.RSS, tags))
+		metrics = append(metrics, NewTaggedMetric("vsize", s.VSize, tags))
+		metrics = append(metrics, NewTaggedMetric("threads", s.Threads, tags))
+		metrics = append(metrics, NewTaggedMetric("fds", s.FDs, tags))
+
+		if m.previous == nil || elapsed <= 0 {
+			continue
+		}
+
+		// cpu is the percent of a single cpu used, so a group using two cpus reports 200
+		metrics = append(metrics, NewRateMetric("cpu_user", s.CPUUser/processClockTicks/elapsed*100, tags))
+		metrics = append(metrics, NewRateMetric("cpu_system", s.CPUSystem/processClockTicks/elapsed*100, tags))
+		metrics = append(metrics, NewRateMetric("cpu", (s.CPUUser+s.CPUSystem)/processClockTicks/elapsed*100, tags))
+		metrics = append(metrics, NewRateMetric("minflt_per_second", s.MinFlt/elapsed, tags))
+		metrics = append(metrics, NewRateMetric("majflt_per_second", s.MajFlt/elapsed, tags))
+		metrics = append(metrics, NewRateMetric("read_bytes_per_second", s.ReadBytes/elapsed, tags))
+		metrics = append(metrics, NewRateMetric("write_bytes_per_second", s.WriteBytes/elapsed, tags))
+	}
+
+	m.previous = samples
+	m.previousTime = now
+
+	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+}
+
+// procstatInfo caches what has been read about a process while it's matched against each group
+type procstatInfo struct {
+	cmdline     *string
+	uid         *string
+	cgroup      *string
+	sample      procstatSample
+	previous    procstatSample
+	hasPrevious bool
+	fds         float64
+}
+
+// matches returns whether the process belongs to the group, reading the files each selector needs the
+// first time they're used
+func (m *ProcstatInputModule) matches(group *ProcessGroup, process *Process, pidFile int64, info *procstatInfo) bool {
+	dir := filepath.Join(m.procPath, strconv.FormatInt(process.Pid, 10))
+
+	if group.PidFile != "" && process.Pid != pidFile {
+		return false
+	}
+
+	if group.Comm != "" && process.Name != group.Comm {
+		return false
+	}
+
+	if group.Cmdline != nil {
+		if info.cmdline == nil {
+			cmdline := GetProcessCmdline(m.procPath, process.Pid)
+			info.cmdline = &cmdline
+		}
+		if !group.Cmdline.MatchString(*info.cmdline) {
+			return false
+		}
+	}
+
+	if group.User != "" {
+		if info.uid == nil {
+			uid := GetProcessUID(m.procPath, process.Pid)
+			info.uid = &uid
+		}
+		if *info.uid != group.User {
+			return false
+		}
+	}
+
+	if group.Cgroup != "" {
+		if info.cgroup == nil {
+			cgroup := readProcessCgroup(dir)
+			info.cgroup = &cgroup
+		}
+		if *info.cgroup != group.Cgroup && !strings.HasPrefix(*info.cgroup, group.Cgroup+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readPidFiles returns the pid in each group's pidfile, or 0 if it doesn't have one or it can't be read
+func (m *ProcstatInputModule) readPidFiles() []int64 {
+	pids := make([]int64, len(m.Groups))
+
+	for i, group := range m.Groups {
+		if group.PidFile == "" {
+			continue
+		}
+
+		// pidfiles are host paths, found under the rootfs when running in a container
+		b, err := ioutil.ReadFile(filepath.Join(m.rootfsPath, group.PidFile))
+		if err != nil {
+			continue
+		}
+
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 0)
+		if err == nil {
+			pids[i] = pid
+		}
+	}
+
+	return pids
+}
+
+func (m *ProcstatInputModule) sample(process *Process) procstatSample {
+	sample := procstatSample{
+		UTime:  process.Fields["utime"],
+		STime:  process.Fields["stime"],
+		MinFlt: process.Fields["minflt"],
+		MajFlt: process.Fields["majflt"],
+	}
+
+	readBytes, writeBytes, err := GetProcessIO(m.procPath, process.Pid)
+	if err == nil {
+		sample.ReadBytes = readBytes
+		sample.WriteBytes = writeBytes
+		sample.HasIO = true
+	}
+
+	return sample
+}
+
+// addProcess adds a process to its group's totals.  The rates only include processes that were also
+// running at the last collection.
+func (m *ProcstatInputModule) addProcess(stats *procstatGroupStats, process *Process, info *procstatInfo) {
+	stats.Count++
+	stats.RSS += process.Fields["rss"] * m.pageSize
+	stats.VSize += process.Fields["vsize"]
+	stats.Threads += process.Fields["num_threads"]
+	stats.FDs += info.fds
+
+	if !info.hasPrevious {
+		return
+	}
+
+	sample, previous := info.sample, info.previous
+	stats.CPUUser += sample.UTime - previous.UTime
+	stats.CPUSystem += sample.STime - previous.STime
+	stats.MinFlt += sample.MinFlt - previous.MinFlt
+	stats.MajFlt += sample.MajFlt - previous.MajFlt
+	if sample.HasIO && previous.HasIO {
+		stats.ReadBytes += sample.ReadBytes - previous.ReadBytes
+		stats.WriteBytes += sample.WriteBytes - previous.WriteBytes
+	}
+}
+
+// countFDs returns the number of open file descriptors of a process, or 0 if they can't be read
+func (m *ProcstatInputModule) countFDs(pid int64) float64 {
+	dir, err := os.Open(filepath.Join(m.procPath, strconv.FormatInt(pid, 10), "fd"))
+	if err != nil {
+		return 0
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0
+	}
+	return float64(len(names))
+}
+
+// readProcessCgroup returns the cgroup v2 path of a process, or its systemd cgroup on a v1 hierarchy,
+// without the leading slash, e.g. system.slice/nginx.service
+func readProcessCgroup(dir string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil {
+		return ""
+	}
+
+	cgroup := ""
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		if fields[1] == "" {
+			return strings.Trim(fields[2], "/")
+		}
+		if fields[1] == "name=systemd" {
+			cgroup = strings.Trim(fields[2], "/")
+		}
+	}
+	return cgroup
+}
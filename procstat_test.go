@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcstatInitErrors(t *testing.T) {
+	group := func(settings map[interface{}]interface{}) map[string]interface{} {
+		return map[string]interface{}{"groups": []interface{}{settings}}
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		err      string
+	}{
+		{"no groups", map[string]interface{}{}, "groups must be specified"},
+		{"no name", group(map[interface{}]interface{}{"comm": "nginx"}), "name must be specified"},
+		{"duplicate name", map[string]interface{}{"groups": []interface{}{
+			map[interface{}]interface{}{"name": "web", "comm": "nginx"},
+			map[interface{}]interface{}{"name": "web", "comm": "apache2"},
+		}}, "web is specified more than once"},
+		{"bad cmdline", group(map[interface{}]interface{}{"name": "web", "cmdline": "nginx("}), "unable to parse cmdline for web"},
+		{"unknown user", group(map[interface{}]interface{}{"name": "web", "user": "no-such-user-sysminerd"}), "unable to find user no-such-user-sysminerd for web"},
+		{"no selector", group(map[interface{}]interface{}{"name": "web"}), "web needs at least one of"},
+	}
+
+	for _, test := range tests {
+		m := &ProcstatInputModule{}
+		err := m.Init(&Config{}, &ModuleConfig{Name: ProcstatModuleName, Settings: test.settings})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Init() returned %v, want an error containing %q", test.name, err, test.err)
+		}
+	}
+}
+
+// procstatFixture is a process written to a fixture proc_path tree
+type procstatFixture struct {
+	Pid       int
+	StartTime int
+	Comm      string
+	Cmdline   string
+	UID       int
+	Cgroup    string
+	UTime     int
+	STime     int
+	MinFlt    int
+	ReadBytes int
+	RSS       int
+	Threads   int
+	FDs       int
+}
+
+func (p procstatFixture) write(t *testing.T, procPath string) {
+	dir := filepath.Join(procPath, strconv.Itoa(p.Pid))
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	stat := fmt.Sprintf("%d (%s) S 1 1 1 0 -1 0 %d 0 0 0 %d %d 0 0 20 0 %d 0 %d 1000000 %d\n",
+		p.Pid, p.Comm, p.MinFlt, p.UTime, p.STime, p.Threads, p.StartTime, p.RSS)
+	writeFixture(t, filepath.Join(dir, "stat"), stat)
+	writeFixture(t, filepath.Join(dir, "cmdline"), strings.Replace(p.Cmdline, " ", "\x00", -1)+"\x00")
+	writeFixture(t, filepath.Join(dir, "status"), fmt.Sprintf("Name:\t%s\nUid:\t%d\t%d\t%d\t%d\n", p.Comm, p.UID, p.UID, p.UID, p.UID))
+	writeFixture(t, filepath.Join(dir, "cgroup"), p.Cgroup)
+	writeFixture(t, filepath.Join(dir, "io"), fmt.Sprintf("rchar: 0\nwchar: 0\nread_bytes: %d\nwrite_bytes: 0\n", p.ReadBytes))
+	for fd := 0; fd < p.FDs; fd++ {
+		writeFixture(t, filepath.Join(dir, "fd", strconv.Itoa(fd)), "")
+	}
+}
+
+// procstatValues keys the metrics of a collection by group and name, e.g. nginx.count
+func procstatValues(moduleMetrics *ModuleMetrics) map[string]float64 {
+	values := make(map[string]float64, len(moduleMetrics.Metrics))
+	for _, metric := range moduleMetrics.Metrics {
+		values[metric.FlatName()] = metric.Value
+	}
+	return values
+}
+
+func TestProcstatGroups(t *testing.T) {
+	procPath := t.TempDir()
+	rootfsPath := t.TempDir()
+	writeFixture(t, filepath.Join(rootfsPath, "run", "nginx.pid"), "100\n")
+
+	master := procstatFixture{Pid: 100, StartTime: 500, Comm: "nginx", Cmdline: "nginx: master process /usr/sbin/nginx",
+		UID: 0, Cgroup: "0::/system.slice/nginx.service\n", UTime: 1000, STime: 500, MinFlt: 100, ReadBytes: 4096, RSS: 10, Threads: 1, FDs: 3}
+	worker := procstatFixture{Pid: 101, StartTime: 600, Comm: "nginx", Cmdline: "nginx: worker process",
+		UID: 33, Cgroup: "0::/system.slice/nginx.service\n", UTime: 5000, STime: 100, RSS: 20, Threads: 4, FDs: 10}
+	// a cgroup v1 host, where the systemd hierarchy holds the unit
+	python := procstatFixture{Pid: 200, StartTime: 700, Comm: "python3", Cmdline: "python3 /opt/app/worker.py --queue jobs",
+		UID: 1000, Cgroup: "12:cpu,cpuacct:/user.slice\n1:name=systemd:/user.slice/user-1000.slice/session-2.scope\n", RSS: 5, Threads: 2}
+	shell := procstatFixture{Pid: 300, StartTime: 800, Comm: "bash", Cmdline: "-bash",
+		UID: 1000, Cgroup: "0::/user.slice/user-1000.slice/user@1000.service/app.slice\n", RSS: 1, Threads: 1}
+	for _, p := range []procstatFixture{master, worker, python, shell} {
+		p.write(t, procPath)
+	}
+	// files in proc that aren't processes are skipped
+	writeFixture(t, filepath.Join(procPath, "stat"), "cpu 0 0 0 0\n")
+	writeFixture(t, filepath.Join(procPath, "self"), "")
+
+	groups := []interface{}{
+		map[interface{}]interface{}{"name": "master", "pidfile": "/run/nginx.pid"},
+		map[interface{}]interface{}{"name": "nginx", "comm": "nginx"},
+		map[interface{}]interface{}{"name": "worker", "cmdline": `worker\.py --queue`},
+		map[interface{}]interface{}{"name": "www", "user": 33},
+		map[interface{}]interface{}{"name": "www-data", "user": "33"},
+		map[interface{}]interface{}{"name": "nginx-service", "cgroup": "/system.slice/nginx.service"},
+		map[interface{}]interface{}{"name": "system", "cgroup": "system.slice"},
+		map[interface{}]interface{}{"name": "partial", "cgroup": "system.sl"},
+		map[interface{}]interface{}{"name": "user-1000", "cgroup": "user.slice/user-1000.slice"},
+		map[interface{}]interface{}{"name": "root-nginx", "comm": "nginx", "user": 0},
+		map[interface{}]interface{}{"name": "missing-pidfile", "pidfile": "/run/missing.pid"},
+	}
+
+	m := &ProcstatInputModule{}
+	err := m.Init(&Config{ProcPath: procPath, RootfsPath: rootfsPath}, &ModuleConfig{Name: ProcstatModuleName, Settings: map[string]interface{}{"groups": groups}})
+	if err != nil {
+		t.Fatalf("Init() returned %v", err)
+	}
+
+	moduleMetrics, err := m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+	got := procstatValues(moduleMetrics)
+
+	counts := map[string]float64{
+		"master":          1,
+		"nginx":           2,
+		"worker":          1,
+		"www":             1,
+		"www-data":        1,
+		"nginx-service":   2,
+		"system":          2,
+		"partial":         0,
+		"user-1000":       2,
+		"root-nginx":      1,
+		"missing-pidfile": 0,
+	}
+	for group, count := range counts {
+		if got[group+".count"] != count {
+			t.Errorf("%s has %v processes, want %v", group, got[group+".count"], count)
+		}
+	}
+
+	pageSize := float64(os.Getpagesize())
+	totals := map[string]float64{
+		"nginx.rss":     30 * pageSize,
+		"nginx.vsize":   2000000,
+		"nginx.threads": 5,
+		"nginx.fds":     13,
+	}
+	for name, total := range totals {
+		if got[name] != total {
+			t.Errorf("%s = %v, want %v", name, got[name], total)
+		}
+	}
+	if _, ok := got["nginx.cpu"]; ok {
+		t.Error("the first collection reported a cpu rate")
+	}
+
+	// ten seconds later the master has used more cpu, and the worker's pid was reused by a new process
+	// whose counters are lower than the old one's
+	master.UTime += 50
+	master.STime += 25
+	master.MinFlt += 100
+	master.ReadBytes += 1000
+	master.write(t, procPath)
+	reused := worker
+	reused.StartTime = 900
+	reused.UTime = 10
+	reused.write(t, procPath)
+	m.previousTime = m.previousTime.Add(-10 * time.Second)
+
+	moduleMetrics, err = m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+	got = procstatValues(moduleMetrics)
+
+	// cpu is the percent of a cpu, from clock ticks, and the new worker has no rates until it's seen
+	// again
+	rates := map[string]float64{
+		"nginx.cpu_user":               5,
+		"nginx.cpu_system":             2.5,
+		"nginx.cpu":                    7.5,
+		"nginx.minflt_per_second":      10,
+		"nginx.majflt_per_second":      0,
+		"nginx.read_bytes_per_second":  100,
+		"nginx.write_bytes_per_second": 0,
+		"worker.cpu":                   0,
+	}
+	for name, rate := range rates {
+		value, ok := got[name]
+		if !ok || math.Abs(value-rate) > 0.01*rate {
+			t.Errorf("%s = %v, want %v", name, value, rate)
+		}
+	}
+	if got["nginx.count"] != 2 {
+		t.Errorf("nginx has %v processes, want the reused pid counted", got["nginx.count"])
+	}
+	if _, ok := m.previous["101:900"]; !ok || len(m.previous) != 4 {
+		t.Errorf("got samples %v, want the reused pid kept under its new start time", m.previous)
+	}
+}
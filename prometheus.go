@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const PrometheusModuleName = "prometheus"
+
+func init() {
+	RegisterModule(PrometheusModuleName, func() Module { return &PrometheusOutputModule{} },
+		ModuleSetting{"listen_address", "address to serve metrics on, defaults to :9275"},
+		ModuleSetting{"path", "http path to serve metrics on, defaults to /metrics"},
+	)
+}
+
+const defaultPrometheusListenAddress = ":9275"
+const defaultPrometheusPath = "/metrics"
+
+var invalidPrometheusNameChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+var invalidPrometheusLabelChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+type PrometheusOutputModule struct {
+	ListenAddress string
+	Path          string
+	globalTags    map[string]string
+	listener      net.Listener
+	lock          sync.RWMutex
+	latestMetrics map[string]*ModuleMetrics
+}
+
+// prometheusFamily groups all samples that share a metric name, since the exposition format requires
+// them to be written together under a single HELP and TYPE line.
+type prometheusFamily struct {
+	Name    string
+	Type    string
+	Help    string
+	Samples []string
+}
+
+func (m *PrometheusOutputModule) Name() string {
+	return PrometheusModuleName
+}
+
+func (m *PrometheusOutputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
+	listenAddress, err := moduleConfig.SettingsString("listen_address")
+	if err != nil || listenAddress == "" {
+		listenAddress = defaultPrometheusListenAddress
+	}
+
+	path, err := moduleConfig.SettingsString("path")
+	if err != nil || path == "" {
+		path = defaultPrometheusPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	// save config data
+	m.ListenAddress = listenAddress
+	m.Path = path
+	m.globalTags = config.Tags
+	m.latestMetrics = make(map[string]*ModuleMetrics)
+
+	listener, err := net.Listen("tcp", m.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %v", m.ListenAddress, err)
+	}
+	m.listener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(m.Path, m.handleMetrics)
+
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			log.Printf("Prometheus server stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Prometheus metrics available at http://%s%s", m.ListenAddress, m.Path)
+
+	return nil
+}
+
+func (m *PrometheusOutputModule) TearDown() error {
+	if m.listener != nil {
+		return m.listener.Close()
+	}
+	return nil
+}
+
+// SendMetrics replaces the latest metrics for each module that was collected this tick.  Modules that
+// weren't collected keep serving their previous metrics.
+func (m *PrometheusOutputModule) SendMetrics(moduleMetrics []*ModuleMetrics) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, module := range moduleMetrics {
+		m.latestMetrics[module.ID()] = module
+	}
+
+	return nil
+}
+
+func (m *PrometheusOutputModule) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(m.Exposition())
+}
+
+// Exposition renders the latest metrics in the Prometheus text exposition format
+func (m *PrometheusOutputModule) Exposition() []byte {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	families := make(map[string]*prometheusFamily)
+
+	for _, module := range m.latestMetrics {
+		for _, metric := range module.Metrics {
+			name := prometheusMetricName(module.Module, metric)
+
+			family, ok := families[name]
+			if !ok {
+				family = &prometheusFamily{
+					Name: name,
+					Type: prometheusType(metric),
+					Help: prometheusHelp(module.Module, metric),
+				}
+				families[name] = family
+			}
+
+			labels := prometheusLabels(module.MetricTags(m.globalTags, metric))
+			value := strconv.FormatFloat(metric.Value, 'g', -1, 64)
+			family.Samples = append(family.Samples, fmt.Sprintf("%s%s %s\n", name, labels, value))
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+	for _, name := range names {
+		family := families[name]
+		sort.Strings(family.Samples)
+
+		fmt.Fprintf(&buffer, "# HELP %s %s\n", name, family.Help)
+		fmt.Fprintf(&buffer, "# TYPE %s %s\n", name, family.Type)
+		for _, sample := range family.Samples {
+			buffer.WriteString(sample)
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+// prometheusMetricName converts a module and dotted metric name into a valid prometheus metric name,
+// e.g. cpu and user become sysminerd_cpu_user.  Counters get the _total suffix prometheus expects of
+// them, e.g. sysminerd_redis_keyspace_hits_total.
+func prometheusMetricName(module string, metric Metric) string {
+	name := invalidPrometheusNameChars.ReplaceAllString(fmt.Sprintf("sysminerd_%s_%s", module, metric.Name), "_")
+	if metric.Type == CounterMetric && !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	return name
+}
+
+// prometheusType returns the prometheus type of a metric.  Rates are derived from counters, but they
+// are already reduced to a change between samples so they are exposed as gauges.
+func prometheusType(metric Metric) string {
+	if metric.Type == CounterMetric {
+		return "counter"
+	}
+	return "gauge"
+}
+
+// prometheusHelp describes a metric, matching the type it's exposed as
+func prometheusHelp(module string, metric Metric) string {
+	switch metric.Type {
+	case RateMetric:
+		return fmt.Sprintf("sysminerd %s %s, gauge of the change in a cumulative counter between collections", module, metric.Name)
+	case CounterMetric:
+		return fmt.Sprintf("sysminerd %s %s, cumulative counter", module, metric.Name)
+	}
+	return fmt.Sprintf("sysminerd %s %s, gauge", module, metric.Name)
+}
+
+func prometheusLabels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	labels := make([]string, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		label := invalidPrometheusLabelChars.ReplaceAllString(key, "_")
+		if label == "" {
+			continue
+		}
+		if label[0] >= '0' && label[0] <= '9' {
+			label = "_" + label
+		}
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", label, prometheusLabelValue(tags[key])))
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func prometheusLabelValue(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\n", "\\n", -1)
+	return strings.Replace(value, "\"", "\\\"", -1)
+}
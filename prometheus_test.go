@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusExpositionTypes(t *testing.T) {
+	m := &PrometheusOutputModule{latestMetrics: make(map[string]*ModuleMetrics)}
+	m.SendMetrics([]*ModuleMetrics{
+		{Module: "redis", Metrics: []Metric{
+			NewMetric("connected_clients", 3),
+			NewCounterMetric("keyspace_hits", 10, nil),
+			NewCounterMetric("requests_total", 5, nil),
+			NewRateMetric("keyspace_hits_per_second", 2.5, nil),
+		}},
+	})
+
+	tests := []struct {
+		name string
+		typ  string
+		help string
+	}{
+		{"sysminerd_redis_connected_clients", "gauge", "sysminerd redis connected_clients, gauge"},
+		{"sysminerd_redis_keyspace_hits_total", "counter", "sysminerd redis keyspace_hits, cumulative counter"},
+		{"sysminerd_redis_requests_total", "counter", "sysminerd redis requests_total, cumulative counter"},
+		{"sysminerd_redis_keyspace_hits_per_second", "gauge", "sysminerd redis keyspace_hits_per_second, gauge of the change in a cumulative counter between collections"},
+	}
+
+	exposition := string(m.Exposition())
+	for _, test := range tests {
+		for _, line := range []string{
+			"# HELP " + test.name + " " + test.help + "\n",
+			"# TYPE " + test.name + " " + test.typ + "\n",
+		} {
+			if !strings.Contains(exposition, line) {
+				t.Errorf("exposition is missing %q:\n%s", line, exposition)
+			}
+		}
+	}
+
+	if strings.Contains(exposition, "_total_total") {
+		t.Errorf("counter already ending in _total got a second suffix:\n%s", exposition)
+	}
+}
+
+func TestPrometheusInitListenError(t *testing.T) {
+	inUse, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inUse.Close()
+
+	m := &PrometheusOutputModule{}
+	err = m.Init(&Config{}, &ModuleConfig{Name: PrometheusModuleName, Settings: map[string]interface{}{
+		"listen_address": inUse.Addr().String(),
+	}})
+	if err == nil {
+		m.TearDown()
+		t.Fatalf("Init() on %s, which is already in use, didn't return an error", inUse.Addr())
+	}
+	if m.listener != nil {
+		t.Error("Init() kept a listener after failing")
+	}
+}
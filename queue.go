@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+)
+
+// default spool limits, used when a spool_dir is set without limits
+const (
+	defaultSpoolMaxSize = 100
+	defaultSpoolMaxAge  = 24 * 60 * 60
+)
+
+// MetricQueue holds serialized metrics that an output module was unable to send, so they can be
+// retried on the next tick.  If MaxSize is greater than zero the oldest metrics are thrown away once
+// the queue grows past it.
+//
+// A queue with a spool writes metrics it couldn't send to disk instead of keeping them in memory.
+// Spooled metrics are returned by Peek before any new metrics, so they are replayed in order, and
+// they are loaded again when the daemon restarts.  MaxSize then only limits the metrics kept in memory
+// when the spool can't be written.
+type MetricQueue struct {
+	Name        string
+	MaxSize     int
+	lines       []string
+	spool       *Spool
+	peekedSpool bool
+}
+
+// NewMetricQueue creates an empty queue.  The name is used when logging overflows.
+func NewMetricQueue(name string, maxSize int) *MetricQueue {
+	return &MetricQueue{Name: name, MaxSize: maxSize}
+}
+
+// NewSpooledMetricQueue creates a queue backed by a spool, which may hold metrics spooled by an earlier
+// run.
+func NewSpooledMetricQueue(name string, maxSize int, spool *Spool) *MetricQueue {
+	return &MetricQueue{Name: name, MaxSize: maxSize, spool: spool}
+}
+
+// newOutputQueue creates the retry queue for an output module from its max_queue_size and spool
+// settings.  Each module spools to its own subdirectory of spool_dir, named after the module id, and
+// modules with several queues name a further subdirectory for each one.
+func newOutputQueue(name string, moduleConfig *ModuleConfig, subdir string) *MetricQueue {
+	maxQueueSize, err := moduleConfig.SettingsInt("max_queue_size")
+	if err != nil {
+		maxQueueSize = 0
+	}
+
+	spoolDir, err := moduleConfig.SettingsString("spool_dir")
+	if err != nil || spoolDir == "" {
+		return NewMetricQueue(name, maxQueueSize)
+	}
+
+	maxSize, err := moduleConfig.SettingsFloat("spool_max_size")
+	if err != nil {
+		maxSize = defaultSpoolMaxSize
+	}
+
+	maxAge, err := moduleConfig.SettingsFloat("spool_max_age")
+	if err != nil {
+		maxAge = defaultSpoolMaxAge
+	}
+
+	dir := filepath.Join(spoolDir, moduleConfig.ID(), subdir)
+	spool, err := OpenSpool(dir, int64(maxSize*1024*1024), secondsToDuration(maxAge))
+	if err != nil {
+		log.Fatalf("Unable to open the %s spool: %v", name, err)
+	}
+
+	return NewSpooledMetricQueue(name, maxQueueSize, spool)
+}
+
+// Push adds metrics to the end of the queue
+func (q *MetricQueue) Push(lines []string) {
+	q.lines = append(q.lines, lines...)
+}
+
+// Peek returns the oldest queued metrics without removing them.  Spooled metrics are returned a batch
+// at a time, so Peek should be called again after Commit until the queue is empty or sending fails.
+func (q *MetricQueue) Peek() []string {
+	q.peekedSpool = false
+
+	if q.spool != nil && q.spool.Lines() > 0 {
+		lines, err := q.spool.Oldest()
+		if err != nil {
+			log.Printf("Failed to read the %s spool: %v", q.Name, err)
+		} else if len(lines) > 0 {
+			q.peekedSpool = true
+			return lines
+		}
+	}
+
+	return q.lines
+}
+
+// Commit removes the first n metrics returned by Peek once they have been sent.  Whatever is left in
+// memory is moved to the spool if there is one, otherwise it's trimmed to MaxSize.
+func (q *MetricQueue) Commit(n int) {
+	if q.peekedSpool {
+		err := q.spool.RemoveOldest(n)
+		if err != nil {
+			log.Printf("Failed to remove sent metrics from the %s spool: %v", q.Name, err)
+		}
+		q.peekedSpool = false
+	} else {
+		if n > len(q.lines) {
+			n = len(q.lines)
+		}
+		remaining := make([]string, len(q.lines)-n, cap(q.lines))
+		copy(remaining, q.lines[n:])
+		q.lines = remaining
+	}
+
+	// unsent metrics are spooled behind the ones already on disk
+	if q.spool != nil && len(q.lines) > 0 {
+		err := q.spool.Write(q.lines)
+		if err == nil {
+			q.lines = nil
+			return
+		}
+		log.Printf("Failed to spool %s metrics: %v", q.Name, err)
+	}
+
+	//see if we need to trim the queued metrics
+	if q.MaxSize > 0 && len(q.lines) > q.MaxSize {
+		log.Printf("%s metric queue overflow, throwing away %d metrics", q.Name, len(q.lines)-q.MaxSize)
+		q.lines = q.lines[len(q.lines)-q.MaxSize:]
+	}
+}
+
+// Drain removes and returns every queued metric, spooled metrics first, so they can be queued
+// somewhere else.  Spooled metrics that can't be read are left in the spool.
+func (q *MetricQueue) Drain() []string {
+	drained := make([]string, 0, q.Len())
+
+	for q.Len() > 0 {
+		lines := q.Peek()
+		if len(lines) == 0 {
+			break
+		}
+		drained = append(drained, lines...)
+		q.Commit(len(lines))
+	}
+
+	return drained
+}
+
+// Len returns the number of queued metrics, including spooled metrics
+func (q *MetricQueue) Len() int {
+	if q.spool != nil {
+		return len(q.lines) + q.spool.Lines()
+	}
+	return len(q.lines)
+}
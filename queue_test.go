@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetricQueueTrimsToMaxSize(t *testing.T) {
+	q := NewMetricQueue("test", 2)
+	q.Push([]string{"a", "b", "c", "d"})
+
+	if got := q.Peek(); len(got) != 4 {
+		t.Errorf("Peek() = %q, want every line until the queue is committed", got)
+	}
+	q.Commit(0)
+	if got := q.Peek(); !reflect.DeepEqual(got, []string{"c", "d"}) {
+		t.Errorf("Peek() = %q, want the newest two lines", got)
+	}
+
+	q.Commit(1)
+	if got := q.Peek(); !reflect.DeepEqual(got, []string{"d"}) || q.Len() != 1 {
+		t.Errorf("Peek() = %q, want d after the oldest was sent", got)
+	}
+}
+
+func TestMetricQueueSpoolsUnsentLinesOnCommit(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+
+	q := NewSpooledMetricQueue("test", 1, spool)
+	q.Push([]string{"a", "b", "c"})
+	if got := q.Peek(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("Peek() = %q, want the pushed lines", got)
+	}
+
+	// only a was sent, the rest goes to disk rather than being trimmed to MaxSize
+	q.Commit(1)
+	if q.Len() != 2 || spool.Lines() != 2 {
+		t.Errorf("queue has %d lines and the spool %d, want both unsent lines spooled", q.Len(), spool.Lines())
+	}
+
+	// the spooled lines survive a restart, and are sent before anything pushed after it
+	reopened, err := OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	q = NewSpooledMetricQueue("test", 1, reopened)
+	q.Push([]string{"d"})
+
+	if got := q.Peek(); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Errorf("Peek() = %q, want the spooled lines first", got)
+	}
+	q.Commit(1)
+	if got := q.Peek(); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("Peek() = %q, want the rest of the spooled batch", got)
+	}
+
+	// d was spooled behind c by the last commit
+	q.Commit(1)
+	if got := q.Peek(); !reflect.DeepEqual(got, []string{"d"}) || reopened.Lines() != 1 {
+		t.Errorf("Peek() = %q, want the line pushed after the restart from the spool", got)
+	}
+	q.Commit(1)
+	if q.Len() != 0 || len(spoolSegmentFiles(t, dir)) != 0 {
+		t.Errorf("queue has %d lines after everything was sent, want none", q.Len())
+	}
+}
+
+func TestMetricQueueDrain(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	spool.Write([]string{"a", "b"})
+	spool.Write([]string{"c"})
+
+	q := NewSpooledMetricQueue("test", 0, spool)
+	q.Push([]string{"d", "e"})
+
+	if got := q.Drain(); !reflect.DeepEqual(got, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("Drain() = %q, want the spooled lines and then the pushed ones", got)
+	}
+	if q.Len() != 0 || spool.Lines() != 0 {
+		t.Errorf("queue has %d lines and the spool %d after draining, want none", q.Len(), spool.Lines())
+	}
+
+	memory := NewMetricQueue("memory", 0)
+	memory.Push([]string{"x", "y"})
+	if got := memory.Drain(); !reflect.DeepEqual(got, []string{"x", "y"}) || memory.Len() != 0 {
+		t.Errorf("Drain() = %q leaving %d, want x and y leaving none", got, memory.Len())
+	}
+}
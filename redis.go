@@ -1,21 +1,83 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/fzzy/radix/redis"
 )
 
 const RedisModuleName = "redis"
 
+const (
+	defaultRedisConnectTimeout = 5
+	defaultRedisTimeout        = 5
+)
+
+// cumulative INFO counters that are also reported as per second rates, named with a _per_second
+// suffix
+var redisCounters = []string{
+	"total_connections_received",
+	"total_commands_processed",
+	"total_net_input_bytes",
+	"total_net_output_bytes",
+	"total_net_repl_input_bytes",
+	"total_net_repl_output_bytes",
+	"total_reads_processed",
+	"total_writes_processed",
+	"total_error_replies",
+	"rejected_connections",
+	"sync_full",
+	"sync_partial_ok",
+	"sync_partial_err",
+	"expired_keys",
+	"evicted_keys",
+	"keyspace_hits",
+	"keyspace_misses",
+	"total_forks",
+	"used_cpu_sys",
+	"used_cpu_user",
+	"used_cpu_sys_children",
+	"used_cpu_user_children",
+}
+
+func init() {
+	settings := []ModuleSetting{
+		{"host", "redis host"},
+		{"port", "redis port"},
+		{"socket", "unix socket path, used instead of host and port"},
+		{"username", "acl username, requires redis 6"},
+		{"password", "password to authenticate with"},
+		{"password_file", "file containing the password, read on every connection"},
+		{"db", "database number to select"},
+		{"connect_timeout", "seconds to wait when connecting, 5 by default"},
+		{"timeout", "seconds to wait for each command, 5 by default"},
+		{"commandstats", "collect per command call counts and times from INFO commandstats"},
+		{"latencystats", "collect per command latency percentiles from INFO latencystats, requires redis 7"},
+	}
+	RegisterModule(RedisModuleName, func() Module { return &RedisInputModule{} },
+		append(settings, tlsSettings...)...)
+}
+
 type RedisInputModule struct {
-	Host   string
-	Port   int
-	client *redis.Client
+	Host           string
+	Port           int
+	Socket         string
+	Username       string
+	Password       string
+	PasswordFile   string
+	DB             int
+	ConnectTimeout time.Duration
+	Timeout        time.Duration
+	CommandStats   bool
+	LatencyStats   bool
+	client         *RedisClient
+	tlsConfig      *tls.Config
+	counters       StringSet
+	rates          CounterRates
 }
 
 func (m *RedisInputModule) Name() string {
@@ -23,27 +85,77 @@ func (m *RedisInputModule) Name() string {
 }
 
 func (m *RedisInputModule) Init(config *Config, moduleConfig *ModuleConfig) error {
-	// parse redis settings
-	redisHost, err := moduleConfig.SettingsString("host")
-	if err != nil || redisHost == "" {
-		log.Fatalf("host must be specified: %v", err)
+	// parse redis settings, a unix socket is used instead of a host and port
+	socket, _ := moduleConfig.SettingsString("socket")
+
+	var redisHost string
+	var redisPort int
+	var err error
+	if socket == "" {
+		redisHost, err = moduleConfig.SettingsString("host")
+		if err != nil || redisHost == "" {
+			log.Fatalf("host must be specified: %v", err)
+		}
+
+		redisPort, err = moduleConfig.SettingsInt("port")
+		if err != nil {
+			log.Fatalf("Unable to parse port: %v", err)
+		} else if redisPort < 1 || redisPort > 65535 {
+			log.Fatalf("invalid port number: %d", redisPort)
+		}
+	}
+
+	username, _ := moduleConfig.SettingsString("username")
+	password, _ := moduleConfig.SettingsString("password")
+	passwordFile, _ := moduleConfig.SettingsString("password_file")
+	if password != "" && passwordFile != "" {
+		log.Fatalf("Only one of password and password_file can be set")
+	}
+	if username != "" && password == "" && passwordFile == "" {
+		log.Fatalf("A password must be set with username")
 	}
 
-	redisPort, err := moduleConfig.SettingsInt("port")
+	db, err := moduleConfig.SettingsInt("db")
 	if err != nil {
-		log.Fatalf("Unable to parse port: %v", err)
-	} else if redisPort < 1 || redisPort > 65535 {
-		log.Fatalf("invalid port number: %d", redisPort)
+		db = 0
+	} else if db < 0 {
+		log.Fatalf("invalid db number: %d", db)
+	}
+
+	connectTimeout, err := moduleConfig.SettingsFloat("connect_timeout")
+	if err != nil || connectTimeout <= 0 {
+		connectTimeout = defaultRedisConnectTimeout
+	}
+
+	timeout, err := moduleConfig.SettingsFloat("timeout")
+	if err != nil || timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+
+	tlsConfig, err := newTLSConfig(moduleConfig)
+	if err != nil {
+		log.Fatalf("Unable to configure redis tls: %v", err)
 	}
 
 	// save config data
 	m.Host = redisHost
 	m.Port = redisPort
+	m.Socket = socket
+	m.Username = username
+	m.Password = password
+	m.PasswordFile = passwordFile
+	m.DB = db
+	m.ConnectTimeout = secondsToDuration(connectTimeout)
+	m.Timeout = secondsToDuration(timeout)
+	m.CommandStats, _ = moduleConfig.SettingsBool("commandstats")
+	m.LatencyStats, _ = moduleConfig.SettingsBool("latencystats")
+	m.tlsConfig = tlsConfig
+	m.counters.AddAll(redisCounters)
 
-	// connect to redis
-	m.client, err = connectToRedis(m.Host, m.Port)
+	// connect to redis, if it isn't up yet the connection is retried on each collection
+	m.client, _ = connectToRedis(m)
 
-	return err
+	return nil
 }
 
 func (m *RedisInputModule) TearDown() error {
@@ -54,40 +166,82 @@ func (m *RedisInputModule) TearDown() error {
 }
 
 func (m *RedisInputModule) GetMetrics() (*ModuleMetrics, error) {
-	metrics := make([]Metric, 0, 48)
+	values, err := m.info()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	metrics := m.parseInfo(values)
+
+	if m.CommandStats {
+		values, err := m.info("commandstats")
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, parseRedisCommandStats(values, &m.rates)...)
+	}
+
+	if m.LatencyStats {
+		values, err := m.info("latencystats")
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, parseRedisLatencyStats(values)...)
+	}
+
+	metrics = append(metrics, m.rates.Rates(now)...)
+	if ratio, ok := m.rates.Ratio("keyspace_hits_per_second", "keyspace_misses_per_second"); ok {
+		metrics = append(metrics, NewMetric("keyspace_hit_ratio", ratio))
+	}
+
+	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+}
 
+// info runs the INFO command for the sections given, reconnecting first if the connection was lost
+func (m *RedisInputModule) info(sections ...string) (string, error) {
 	// attempt to reconnect to redis
 	if m.client == nil {
-		client, err := connectToRedis(m.Host, m.Port)
+		client, err := connectToRedis(m)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 		log.Print("Reconnected to redis")
 		m.client = client
 	}
 
-	reply := m.client.Cmd("INFO")
-	if reply.Err != nil {
-		log.Printf("Error collecting metrics from redis: %v", reply.Err)
+	values, err := m.client.Str(append([]string{"INFO"}, sections...)...)
+	if err != nil {
+		if _, ok := err.(RedisError); ok {
+			log.Printf("Problem processing redis reply: %v", err)
+			return "", err
+		}
+
+		log.Printf("Error collecting metrics from redis: %v", err)
 
 		// close the existing connection
 		m.client.Close()
 		m.client = nil
 
-		return nil, reply.Err
+		return "", err
 	}
 
-	values, err := reply.Str()
-	if err != nil {
-		log.Printf("Problem processing redis reply: %v", err)
-		return nil, err
-	}
+	return values, nil
+}
+
+// parseInfo converts the numeric fields of INFO into metrics, along with the lag of each replica of a
+// master.  The fields that are counters are added to the module's rates.
+func (m *RedisInputModule) parseInfo(values string) []Metric {
+	metrics := make([]Metric, 0, 48)
 
 	lines := strings.Split(values, "\n")
 
 	processStats := false
 	processKeyspace := false
 
+	masterOffset := -1.0
+	replicas := make([]map[string]string, 0)
+
 	for _, line := range lines {
 		if strings.Contains(line, "# Clients") {
 			processStats = true
@@ -101,7 +255,7 @@ func (m *RedisInputModule) GetMetrics() (*ModuleMetrics, error) {
 			continue
 		}
 
-		fields := strings.Split(line, ":")
+		fields := strings.SplitN(line, ":", 2)
 		if len(fields) != 2 {
 			continue
 		}
@@ -116,19 +270,18 @@ func (m *RedisInputModule) GetMetrics() (*ModuleMetrics, error) {
 
 		if processKeyspace {
 			// format db0:keys=1,expires=0,avg_ttl=0
-			dbValues := strings.Split(svalue, ",")
-			for _, dbLine := range dbValues {
-				fields = strings.Split(dbLine, "=")
-				dbKey := fields[0]
-				svalue = fields[1]
+			tags := map[string]string{"db": key}
+			for dbKey, svalue := range parseRedisValues(svalue) {
 				dbValue, err := strconv.ParseFloat(svalue, 64)
 				if err != nil {
 					continue
 				}
-				dbKey = fmt.Sprintf("%s.%s", key, dbKey)
 
-				metrics = append(metrics, NewMetric(dbKey, dbValue))
+				metrics = append(metrics, NewTaggedMetric(dbKey, dbValue, tags))
 			}
+		} else if strings.HasPrefix(key, "slave") && strings.Contains(svalue, "=") {
+			// format slave0:ip=10.0.0.2,port=6379,state=online,offset=1234,lag=0
+			replicas = append(replicas, parseRedisValues(svalue))
 		} else {
 			// format used_cpu_user_children:0.00
 			// throwaway non numeric values
@@ -138,18 +291,160 @@ func (m *RedisInputModule) GetMetrics() (*ModuleMetrics, error) {
 			}
 
 			metrics = append(metrics, NewMetric(key, value))
+
+			if m.counters.Contains(key) {
+				m.rates.Add(Counter{Name: key + "_per_second", Value: value})
+			}
+			if key == "master_repl_offset" {
+				masterOffset = value
+			}
 		}
 	}
 
-	return &ModuleMetrics{Module: m.Name(), Metrics: metrics}, nil
+	for _, replica := range replicas {
+		tags := map[string]string{"replica": fmt.Sprintf("%s:%s", replica["ip"], replica["port"])}
+
+		offset, err := strconv.ParseFloat(replica["offset"], 64)
+		if err == nil && masterOffset >= 0 {
+			metrics = append(metrics, NewTaggedMetric("replica_lag_bytes", masterOffset-offset, tags))
+		}
+
+		lag, err := strconv.ParseFloat(replica["lag"], 64)
+		if err == nil {
+			metrics = append(metrics, NewTaggedMetric("replica_lag_seconds", lag, tags))
+		}
+	}
+
+	return metrics
+}
+
+// parseRedisCommandStats converts INFO commandstats into metrics tagged with the command, e.g.
+// cmdstat_get:calls=10,usec=25,usec_per_call=2.50,rejected_calls=0,failed_calls=0.  The call counts
+// and times are added to rates.
+func parseRedisCommandStats(values string, rates *CounterRates) []Metric {
+	metrics := make([]Metric, 0, 64)
+
+	for _, line := range strings.Split(values, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "cmdstat_") {
+			continue
+		}
+
+		command := strings.TrimPrefix(fields[0], "cmdstat_")
+		tags := map[string]string{"command": command}
+
+		for key, svalue := range parseRedisValues(fields[1]) {
+			value, err := strconv.ParseFloat(svalue, 64)
+			if err != nil {
+				continue
+			}
+
+			metrics = append(metrics, NewTaggedMetric(key, value, tags))
+
+			if key != "usec_per_call" {
+				rates.Add(Counter{Name: key + "_per_second", Tags: tags, Value: value})
+			}
+		}
+	}
+
+	return metrics
+}
+
+// parseRedisLatencyStats converts INFO latencystats into latency_usec metrics tagged with the command
+// and percentile, e.g. latency_percentiles_usec_get:p50=1.003,p99=2.007,p99.9=3.007
+func parseRedisLatencyStats(values string) []Metric {
+	metrics := make([]Metric, 0, 64)
+
+	for _, line := range strings.Split(values, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "latency_percentiles_usec_") {
+			continue
+		}
+
+		command := strings.TrimPrefix(fields[0], "latency_percentiles_usec_")
+
+		for percentile, svalue := range parseRedisValues(fields[1]) {
+			value, err := strconv.ParseFloat(svalue, 64)
+			if err != nil {
+				continue
+			}
+
+			tags := map[string]string{"command": command, "percentile": percentile}
+			metrics = append(metrics, NewTaggedMetric("latency_usec", value, tags))
+		}
+	}
+
+	return metrics
+}
+
+// parseRedisValues splits a comma separated list of key=value pairs
+func parseRedisValues(svalue string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(svalue, ",") {
+		fields := strings.SplitN(pair, "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		values[fields[0]] = fields[1]
+	}
+	return values
 }
 
-func connectToRedis(host string, port int) (*redis.Client, error) {
-	address := fmt.Sprintf("%s:%d", host, port)
-	client, err := redis.DialTimeout("tcp", address, 5*time.Second)
+// connectToRedis connects to the module's redis server, authenticating and selecting the database
+// if they're configured.  It's used for every reconnect, and the password file is read each time so a
+// rotated password is picked up.
+func connectToRedis(m *RedisInputModule) (*RedisClient, error) {
+	network, address := "tcp", fmt.Sprintf("%s:%d", m.Host, m.Port)
+	if m.Socket != "" {
+		network, address = "unix", m.Socket
+	}
+
+	conn, err := dialTimeout(network, address, m.ConnectTimeout, m.tlsConfig)
 	if err != nil {
 		log.Printf("Failed to connect to redis: %v", err)
+		return nil, err
+	}
+
+	client := NewRedisClient(conn, m.Timeout)
+
+	err = setupRedisClient(m, client)
+	if err != nil {
+		log.Printf("Failed to set up the redis connection: %v", err)
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func setupRedisClient(m *RedisInputModule, client *RedisClient) error {
+	password := m.Password
+	if m.PasswordFile != "" {
+		data, err := ioutil.ReadFile(m.PasswordFile)
+		if err != nil {
+			return err
+		}
+		password = strings.TrimSpace(string(data))
+	}
+
+	if password != "" {
+		var err error
+		if m.Username != "" {
+			_, err = client.Cmd("AUTH", m.Username, password)
+		} else {
+			_, err = client.Cmd("AUTH", password)
+		}
+		if err != nil {
+			return fmt.Errorf("AUTH failed: %v", err)
+		}
+	}
+
+	if m.DB != 0 {
+		_, err := client.Cmd("SELECT", strconv.Itoa(m.DB))
+		if err != nil {
+			return fmt.Errorf("SELECT failed: %v", err)
+		}
 	}
 
-	return client, err
+	return nil
 }
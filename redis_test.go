@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redisBulk encodes INFO output, written with \n line endings, as the bulk string redis replies with
+func redisBulk(value string) string {
+	value = strings.Replace(value, "\n", "\r\n", -1)
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+const redisInfo = `# Server
+redis_version:7.2.4
+uptime_in_seconds:100
+
+# Clients
+connected_clients:3
+
+# Memory
+used_memory:1024
+used_memory_human:1.00K
+
+# Stats
+total_commands_processed:%d
+keyspace_hits:%d
+keyspace_misses:%d
+
+# Replication
+role:master
+connected_slaves:2
+slave0:ip=10.0.0.2,port=6379,state=online,offset=1000,lag=0
+slave1:ip=10.0.0.3,port=6380,state=online,offset=900,lag=2
+master_repl_offset:1200
+
+# Keyspace
+db0:keys=5,expires=1,avg_ttl=0
+`
+
+const redisCommandStats = `# Commandstats
+cmdstat_get:calls=%d,usec=%d,usec_per_call=2.50,rejected_calls=0,failed_calls=0
+`
+
+const redisLatencyStats = `# Latencystats
+latency_percentiles_usec_get:p50=1.003,p99=2.007,p99.9=3.007
+`
+
+// redisMetricValues keys metrics by their tag values and name, e.g. get.calls
+func redisMetricValues(metrics []Metric) map[string]float64 {
+	values := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		values[metric.FlatName()] = metric.Value
+	}
+	return values
+}
+
+func TestRedisGetMetrics(t *testing.T) {
+	client, fake := newFakeRedis(t,
+		redisBulk(fmt.Sprintf(redisInfo, 100, 30, 10)),
+		redisBulk(fmt.Sprintf(redisCommandStats, 10, 25)),
+		redisBulk(redisLatencyStats),
+		redisBulk(fmt.Sprintf(redisInfo, 200, 60, 20)),
+		redisBulk(fmt.Sprintf(redisCommandStats, 30, 75)),
+		redisBulk(redisLatencyStats),
+	)
+	defer client.Close()
+
+	m := &RedisInputModule{client: client, CommandStats: true, LatencyStats: true, counters: StringSet{}}
+	m.counters.AddAll(redisCounters)
+
+	moduleMetrics, err := m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+
+	// fields before # Clients, human readable and non numeric values, and the slave lines themselves
+	// aren't reported, and the first collection has no rates
+	want := map[string]float64{
+		"connected_clients":                 3,
+		"used_memory":                       1024,
+		"total_commands_processed":          100,
+		"keyspace_hits":                     30,
+		"keyspace_misses":                   10,
+		"connected_slaves":                  2,
+		"master_repl_offset":                1200,
+		"db0.keys":                          5,
+		"db0.expires":                       1,
+		"db0.avg_ttl":                       0,
+		"10.0.0.2:6379.replica_lag_bytes":   200,
+		"10.0.0.2:6379.replica_lag_seconds": 0,
+		"10.0.0.3:6380.replica_lag_bytes":   300,
+		"10.0.0.3:6380.replica_lag_seconds": 2,
+		"get.calls":                         10,
+		"get.usec":                          25,
+		"get.usec_per_call":                 2.5,
+		"get.rejected_calls":                0,
+		"get.failed_calls":                  0,
+		"get.p50.latency_usec":              1.003,
+		"get.p99.latency_usec":              2.007,
+		"get.p99.9.latency_usec":            3.007,
+	}
+	if got := redisMetricValues(moduleMetrics.Metrics); !reflect.DeepEqual(got, want) {
+		t.Errorf("got metrics %v, want %v", got, want)
+	}
+
+	for _, metric := range moduleMetrics.Metrics {
+		switch metric.Name {
+		case "replica_lag_bytes":
+			if len(metric.Tags) != 1 || metric.Tags["replica"] == "" {
+				t.Errorf("%s is tagged %v, want only the replica", metric.Name, metric.Tags)
+			}
+		case "latency_usec":
+			if metric.Tags["command"] != "get" || metric.Tags["percentile"] == "" {
+				t.Errorf("%s is tagged %v, want the command and percentile", metric.Name, metric.Tags)
+			}
+		}
+	}
+
+	for _, command := range [][]string{{"INFO"}, {"INFO", "commandstats"}, {"INFO", "latencystats"}} {
+		if got := <-fake.commands; !reflect.DeepEqual(got, command) {
+			t.Errorf("sent %q, want %q", got, command)
+		}
+	}
+
+	// the second collection is ten seconds after the first
+	m.rates.previousTime = m.rates.previousTime.Add(-10 * time.Second)
+
+	moduleMetrics, err = m.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() returned %v", err)
+	}
+	got := redisMetricValues(moduleMetrics.Metrics)
+
+	rates := map[string]float64{
+		"total_commands_processed_per_second": 10,
+		"keyspace_hits_per_second":            3,
+		"keyspace_misses_per_second":          1,
+		"get.calls_per_second":                2,
+		"get.usec_per_second":                 5,
+		"get.rejected_calls_per_second":       0,
+		"keyspace_hit_ratio":                  0.75,
+	}
+	for name, rate := range rates {
+		value, ok := got[name]
+		if !ok || math.Abs(value-rate) > rate*0.01 {
+			t.Errorf("%s = %v, want %v", name, value, rate)
+		}
+	}
+	if _, ok := got["get.usec_per_call_per_second"]; ok {
+		t.Error("usec_per_call isn't a counter, but its rate was reported")
+	}
+	for _, metric := range moduleMetrics.Metrics {
+		if strings.HasSuffix(metric.Name, "_per_second") && metric.Type != RateMetric {
+			t.Errorf("%s has type %v, want a rate", metric.Name, metric.Type)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ModuleFactory creates a new module, which is initialized after it's created
+type ModuleFactory func() Module
+
+// ModuleSetting describes a setting a module accepts in its config file
+type ModuleSetting struct {
+	Name        string
+	Description string
+}
+
+type registeredModule struct {
+	Name     string
+	Factory  ModuleFactory
+	Settings []ModuleSetting
+}
+
+var moduleRegistry = make(map[string]*registeredModule)
+
+// RegisterModule makes a module available to config files under the given name.  It is meant to be
+// called from an init function in the file that defines the module, and panics if the name is
+// already registered.
+func RegisterModule(name string, factory ModuleFactory, settings ...ModuleSetting) {
+	if _, ok := moduleRegistry[name]; ok {
+		panic(fmt.Sprintf("module %s is already registered", name))
+	}
+	moduleRegistry[name] = &registeredModule{Name: name, Factory: factory, Settings: settings}
+}
+
+// getModule creates a new module for the registered name
+func getModule(name string) (Module, error) {
+	registered, ok := moduleRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("no module is registered as %s", name)
+	}
+	return registered.Factory(), nil
+}
+
+// moduleKind returns whether the module is an input, transform, or output module
+func moduleKind(module Module) string {
+	switch module.(type) {
+	case InputModule:
+		return InputModuleKind
+	case TransformModule:
+		return TransformModuleKind
+	case OutputModule:
+		return OutputModuleKind
+	default:
+		return "unknown"
+	}
+}
+
+// listModules writes every registered module with its kind and settings
+func listModules(w io.Writer) {
+	names := make([]string, 0, len(moduleRegistry))
+	for name := range moduleRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		registered := moduleRegistry[name]
+		fmt.Fprintf(w, "%s (%s)\n", name, moduleKind(registered.Factory()))
+		for _, setting := range registered.Settings {
+			fmt.Fprintf(w, "    %s: %s\n", setting.Name, setting.Description)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisClient is a minimal client for the redis protocol, covering the commands the redis input
+// module runs.  It works over any connection, so the connection can be tls or a unix socket.
+type RedisClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// RedisError is an error reply from redis.  Unlike other errors from Cmd, the connection can still be
+// used after one.
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+// NewRedisClient creates a client on an open connection.  Each command must complete within the
+// timeout unless it's zero.
+func NewRedisClient(conn net.Conn, timeout time.Duration) *RedisClient {
+	return &RedisClient{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+}
+
+// Cmd sends a command and reads its reply.  Simple and bulk strings are returned as a string,
+// integers as an int64, arrays as an []interface{}, and null replies as nil.  Error replies are
+// returned as a RedisError.
+func (c *RedisClient) Cmd(args ...string) (interface{}, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&request, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	_, err := c.conn.Write(request.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+}
+
+// Str sends a command that replies with a string
+func (c *RedisClient) Str(args ...string) (string, error) {
+	reply, err := c.Cmd(args...)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected reply to %s: %v", args[0], reply)
+	}
+
+	return value, nil
+}
+
+func (c *RedisClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *RedisClient) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("invalid redis reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(c.reader, data)
+		if err != nil {
+			return nil, err
+		}
+
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+
+		values := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			value, err := c.readReply()
+			if err != nil {
+				// the rest of the array can't be skipped after a network error, but an error reply
+				// inside an array is just another value
+				if _, ok := err.(RedisError); !ok {
+					return nil, err
+				}
+				value = err
+			}
+			values = append(values, value)
+		}
+
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type %q", line[0])
+	}
+}
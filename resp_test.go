@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRedis answers each command it reads with the next of its replies, and records the commands
+type fakeRedis struct {
+	conn     net.Conn
+	replies  []string
+	commands chan []string
+}
+
+func newFakeRedis(t *testing.T, replies ...string) (*RedisClient, *fakeRedis) {
+	client, server := net.Pipe()
+	fake := &fakeRedis{conn: server, replies: replies, commands: make(chan []string, len(replies))}
+	go fake.serve(t)
+	return NewRedisClient(client, time.Second), fake
+}
+
+func (f *fakeRedis) serve(t *testing.T) {
+	defer close(f.commands)
+	defer f.conn.Close()
+	reader := bufio.NewReader(f.conn)
+
+	for _, reply := range f.replies {
+		command, err := readFakeRedisCommand(reader)
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("fake redis failed to read a command: %v", err)
+			}
+			return
+		}
+		f.commands <- command
+
+		// an empty reply hangs up without answering
+		if reply == "" {
+			return
+		}
+		_, err = f.conn.Write([]byte(reply))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
+
+func TestRedisClientReplies(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		expected interface{}
+		err      error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"integer", ":42\r\n", int64(42), nil},
+		{"bulk string", "$5\r\nhello\r\n", "hello", nil},
+		{"bulk string with line breaks", "$12\r\nline1\r\nline2\r\n", "line1\r\nline2", nil},
+		{"empty bulk string", "$0\r\n\r\n", "", nil},
+		{"nil bulk string", "$-1\r\n", nil, nil},
+		{"nil array", "*-1\r\n", nil, nil},
+		{"empty array", "*0\r\n", []interface{}{}, nil},
+		{
+			"array",
+			"*3\r\n$3\r\nfoo\r\n:1\r\n$-1\r\n",
+			[]interface{}{"foo", int64(1), nil},
+			nil,
+		},
+		{
+			"nested array with an error",
+			"*2\r\n*1\r\n+OK\r\n-ERR nested\r\n",
+			[]interface{}{[]interface{}{"OK"}, RedisError("ERR nested")},
+			nil,
+		},
+		{"error", "-ERR unknown command 'FOO'\r\n", nil, RedisError("ERR unknown command 'FOO'")},
+	}
+
+	for _, test := range tests {
+		client, fake := newFakeRedis(t, test.reply)
+
+		reply, err := client.Cmd("TEST", "arg")
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		if !reflect.DeepEqual(reply, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, reply)
+		}
+		if command := <-fake.commands; !reflect.DeepEqual(command, []string{"TEST", "arg"}) {
+			t.Errorf("%s: expected the command to be sent as an array, got %q", test.name, command)
+		}
+
+		client.Close()
+	}
+}
+
+func TestRedisClientInvalidReplies(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+	}{
+		{"unknown type", "!oops\r\n"},
+		{"missing carriage return", "+OK\n"},
+		{"invalid bulk length", "$abc\r\n"},
+		{"invalid array length", "*abc\r\n"},
+		{"truncated bulk string", "$10\r\nhello"},
+		{"truncated array", "*2\r\n+OK\r\n"},
+	}
+
+	for _, test := range tests {
+		client, _ := newFakeRedis(t, test.reply)
+
+		_, err := client.Cmd("TEST")
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if _, ok := err.(RedisError); ok {
+			t.Errorf("%s: expected a protocol error rather than a redis error, got %v", test.name, err)
+		}
+
+		client.Close()
+	}
+}
+
+func TestRedisClientStr(t *testing.T) {
+	client, _ := newFakeRedis(t, "$4\r\ninfo\r\n", ":1\r\n")
+	defer client.Close()
+
+	value, err := client.Str("INFO")
+	if err != nil || value != "info" {
+		t.Errorf("expected info, got %q and %v", value, err)
+	}
+
+	_, err = client.Str("INFO")
+	if err == nil {
+		t.Error("expected an error for an integer reply")
+	}
+}
+
+func TestSetupRedisClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		module   RedisInputModule
+		replies  []string
+		commands [][]string
+		err      string
+	}{
+		{
+			name:    "nothing to set up",
+			module:  RedisInputModule{},
+			replies: nil,
+		},
+		{
+			name:     "password and db",
+			module:   RedisInputModule{Password: "secret", DB: 2},
+			replies:  []string{"+OK\r\n", "+OK\r\n"},
+			commands: [][]string{{"AUTH", "secret"}, {"SELECT", "2"}},
+		},
+		{
+			name:     "acl user",
+			module:   RedisInputModule{Username: "metrics", Password: "secret"},
+			replies:  []string{"+OK\r\n"},
+			commands: [][]string{{"AUTH", "metrics", "secret"}},
+		},
+		{
+			name:     "wrong password",
+			module:   RedisInputModule{Password: "wrong", DB: 2},
+			replies:  []string{"-WRONGPASS invalid username-password pair\r\n"},
+			commands: [][]string{{"AUTH", "wrong"}},
+			err:      "AUTH failed: WRONGPASS invalid username-password pair",
+		},
+		{
+			name:     "invalid db",
+			module:   RedisInputModule{Password: "secret", DB: 99},
+			replies:  []string{"+OK\r\n", "-ERR DB index is out of range\r\n"},
+			commands: [][]string{{"AUTH", "secret"}, {"SELECT", "99"}},
+			err:      "SELECT failed: ERR DB index is out of range",
+		},
+		{
+			name:     "connection closed during auth",
+			module:   RedisInputModule{Password: "secret"},
+			replies:  []string{""},
+			commands: [][]string{{"AUTH", "secret"}},
+			err:      "AUTH failed: EOF",
+		},
+	}
+
+	for _, test := range tests {
+		client, fake := newFakeRedis(t, test.replies...)
+
+		err := setupRedisClient(&test.module, client)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+
+		client.Close()
+		var commands [][]string
+		for command := range fake.commands {
+			commands = append(commands, command)
+		}
+		if !reflect.DeepEqual(commands, test.commands) {
+			t.Errorf("%s: expected commands %q, got %q", test.name, test.commands, commands)
+		}
+	}
+}
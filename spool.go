@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const spoolSegmentExtension = ".seg"
+
+// Spool persists batches of serialized metrics as segment files in a directory, so metrics that an
+// output module couldn't send survive an agent restart.  Segments are read back oldest first.  Once
+// the segments are larger than MaxBytes, or older than MaxAge, the oldest are thrown away.  A zero
+// limit is unlimited.
+type Spool struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+	segments []*spoolSegment
+	size     int64
+	lines    int
+	sequence int
+}
+
+// spoolSegment is a single batch on disk.  The file name holds the creation time, a sequence number to
+// keep batches written in the same nanosecond ordered, and the number of lines in the segment, so the
+// spool can be indexed at startup without reading every segment.
+type spoolSegment struct {
+	Name    string
+	Created time.Time
+	Lines   int
+	Size    int64
+}
+
+// OpenSpool creates the spool directory if needed and loads any segments left by a previous run
+func OpenSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &Spool{Dir: dir, MaxBytes: maxBytes, MaxAge: maxAge}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), spoolSegmentExtension) {
+			continue
+		}
+
+		segment, err := parseSpoolSegment(file.Name(), file.Size())
+		if err != nil {
+			log.Printf("Ignoring unknown spool file %s: %v", filepath.Join(dir, file.Name()), err)
+			continue
+		}
+		spool.segments = append(spool.segments, segment)
+		spool.size += segment.Size
+		spool.lines += segment.Lines
+	}
+
+	// file names sort in the order they were written
+	sort.Sort(spoolSegmentsByName(spool.segments))
+
+	if len(spool.segments) > 0 {
+		log.Printf("Loaded %d spooled metrics from %s", spool.lines, dir)
+	}
+
+	spool.enforceLimits()
+
+	return spool, nil
+}
+
+// Write adds a batch of lines as the newest segment
+func (s *Spool) Write(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	s.sequence++
+	name := fmt.Sprintf("%020d-%06d-%d%s", now.UnixNano(), s.sequence%1000000, len(lines), spoolSegmentExtension)
+
+	size, err := s.writeSegment(name, lines)
+	if err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, &spoolSegment{Name: name, Created: now, Lines: len(lines), Size: size})
+	s.size += size
+	s.lines += len(lines)
+
+	s.enforceLimits()
+
+	return nil
+}
+
+// Oldest returns the lines of the oldest segment, or nil if the spool is empty
+func (s *Spool) Oldest() ([]string, error) {
+	for len(s.segments) > 0 {
+		segment := s.segments[0]
+
+		lines, err := s.readSegment(segment.Name)
+		if err == nil && len(lines) > 0 {
+			return lines, nil
+		}
+
+		// a segment that can't be read would block every later segment
+		if err != nil {
+			log.Printf("Discarding unreadable spool segment %s: %v", segment.Name, err)
+		}
+		err = s.dropOldest()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// RemoveOldest removes the first n lines of the oldest segment once they have been sent, deleting the
+// segment if nothing is left in it.
+func (s *Spool) RemoveOldest(n int) error {
+	if len(s.segments) == 0 || n <= 0 {
+		return nil
+	}
+
+	segment := s.segments[0]
+
+	if n < segment.Lines {
+		lines, err := s.readSegment(segment.Name)
+		if err != nil {
+			return err
+		}
+		if n < len(lines) {
+			return s.rewriteOldest(lines[n:])
+		}
+	}
+
+	return s.dropOldest()
+}
+
+// dropOldest deletes the oldest segment
+func (s *Spool) dropOldest() error {
+	segment := s.segments[0]
+
+	err := os.Remove(filepath.Join(s.Dir, segment.Name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.segments = s.segments[1:]
+	s.size -= segment.Size
+	s.lines -= segment.Lines
+
+	return nil
+}
+
+// Lines returns the number of spooled lines
+func (s *Spool) Lines() int {
+	return s.lines
+}
+
+// rewriteOldest replaces the oldest segment with the lines that haven't been sent yet, keeping its
+// position in the spool.
+func (s *Spool) rewriteOldest(lines []string) error {
+	segment := s.segments[0]
+
+	prefix := strings.TrimSuffix(segment.Name, spoolSegmentExtension)
+	prefix = prefix[:strings.LastIndex(prefix, "-")]
+	name := fmt.Sprintf("%s-%d%s", prefix, len(lines), spoolSegmentExtension)
+
+	size, err := s.writeSegment(name, lines)
+	if err != nil {
+		return err
+	}
+	if name != segment.Name {
+		os.Remove(filepath.Join(s.Dir, segment.Name))
+	}
+
+	s.size += size - segment.Size
+	s.lines += len(lines) - segment.Lines
+	s.segments[0] = &spoolSegment{Name: name, Created: segment.Created, Lines: len(lines), Size: size}
+
+	return nil
+}
+
+// enforceLimits throws away the oldest segments until the spool is within its size and age limits.
+// The newest segment is always kept.
+func (s *Spool) enforceLimits() {
+	dropped := 0
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		tooBig := s.MaxBytes > 0 && s.size > s.MaxBytes
+		tooOld := s.MaxAge > 0 && time.Since(oldest.Created) > s.MaxAge
+		if !tooBig && !tooOld {
+			break
+		}
+
+		dropped += oldest.Lines
+		err := s.dropOldest()
+		if err != nil {
+			log.Printf("Failed to remove spool segment %s: %v", oldest.Name, err)
+			break
+		}
+	}
+
+	if dropped > 0 {
+		log.Printf("Spool %s is over its limits, throwing away %d metrics", s.Dir, dropped)
+	}
+}
+
+// writeSegment writes the lines to a temporary file and renames it into place, so a crash never
+// leaves a partial segment.  Each line is quoted so lines may contain newlines.
+func (s *Spool) writeSegment(name string, lines []string) (int64, error) {
+	path := filepath.Join(s.Dir, name)
+	tmpPath := path + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, line := range lines {
+		writer.WriteString(strconv.Quote(line))
+		writer.WriteByte('\n')
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+func (s *Spool) readSegment(name string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	quoted := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	lines := make([]string, 0, len(quoted))
+	for _, q := range quoted {
+		if q == "" {
+			continue
+		}
+		line, err := strconv.Unquote(q)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+func parseSpoolSegment(name string, size int64) (*spoolSegment, error) {
+	fields := strings.Split(strings.TrimSuffix(name, spoolSegmentExtension), "-")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid segment name")
+	}
+
+	created, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return &spoolSegment{Name: name, Created: time.Unix(0, created), Lines: lines, Size: size}, nil
+}
+
+type spoolSegmentsByName []*spoolSegment
+
+func (s spoolSegmentsByName) Len() int           { return len(s) }
+func (s spoolSegmentsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s spoolSegmentsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
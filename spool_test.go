@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readSpool removes every batch from the spool, oldest first, and returns their lines
+func readSpool(t *testing.T, spool *Spool) []string {
+	lines := make([]string, 0, spool.Lines())
+	for spool.Lines() > 0 {
+		batch, err := spool.Oldest()
+		if err != nil {
+			t.Fatalf("Oldest() returned %v", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		lines = append(lines, batch...)
+		if err := spool.RemoveOldest(len(batch)); err != nil {
+			t.Fatalf("RemoveOldest() returned %v", err)
+		}
+	}
+	return lines
+}
+
+func spoolSegmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSpoolReplaysInOrderAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	batches := [][]string{
+		{"a.b 1 100", "a.c 2 100"},
+		{"a.b 3 110"},
+		{"line with\na newline", `"quoted" \ line`},
+	}
+	for _, batch := range batches {
+		if err := spool.Write(batch); err != nil {
+			t.Fatalf("Write() returned %v", err)
+		}
+	}
+
+	reopened, err := OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	if reopened.Lines() != 5 {
+		t.Errorf("reopened spool has %d lines, want 5", reopened.Lines())
+	}
+
+	want := []string{"a.b 1 100", "a.c 2 100", "a.b 3 110", "line with\na newline", `"quoted" \ line`}
+	if got := readSpool(t, reopened); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+	if files := spoolSegmentFiles(t, dir); len(files) != 0 {
+		t.Errorf("segments %v are left after every line was removed", files)
+	}
+}
+
+func TestSpoolRemoveOldestPartially(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	spool.Write([]string{"a", "b", "c"})
+	spool.Write([]string{"d"})
+
+	if err := spool.RemoveOldest(1); err != nil {
+		t.Fatalf("RemoveOldest() returned %v", err)
+	}
+	if spool.Lines() != 3 {
+		t.Errorf("spool has %d lines, want 3", spool.Lines())
+	}
+	oldest, err := spool.Oldest()
+	if err != nil || !reflect.DeepEqual(oldest, []string{"b", "c"}) {
+		t.Errorf("Oldest() = %q, %v, want the unsent b and c", oldest, err)
+	}
+
+	// the rewritten segment keeps its place, and its line count is right after a restart
+	reopened, err := OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	if reopened.Lines() != 3 {
+		t.Errorf("reopened spool has %d lines, want 3", reopened.Lines())
+	}
+	if got := readSpool(t, reopened); !reflect.DeepEqual(got, []string{"b", "c", "d"}) {
+		t.Errorf("replayed %q, want b, c, and d", got)
+	}
+}
+
+func TestSpoolSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	// each segment is a quoted 4 byte line and a newline, 7 bytes
+	spool, err := OpenSpool(dir, 20, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	for _, line := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		spool.Write([]string{line})
+	}
+
+	if got := readSpool(t, spool); !reflect.DeepEqual(got, []string{"cccc", "dddd"}) {
+		t.Errorf("kept %q, want the newest segments that fit in 20 bytes", got)
+	}
+
+	// the newest segment is kept even if it's over the limit by itself
+	spool.Write([]string{"a line longer than the whole spool"})
+	if spool.Lines() != 1 {
+		t.Errorf("spool has %d lines, want the newest segment", spool.Lines())
+	}
+}
+
+func TestSpoolAgeLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	// segments left by an earlier run, two hours and a minute old
+	for i, age := range []time.Duration{2 * time.Hour, time.Minute} {
+		name := fmt.Sprintf("%020d-%06d-%d%s", time.Now().Add(-age).UnixNano(), i, 1, spoolSegmentExtension)
+		line := fmt.Sprintf("%q\n", fmt.Sprintf("old %d", i))
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(line), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spool, err := OpenSpool(dir, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	if spool.Lines() != 1 || len(spoolSegmentFiles(t, dir)) != 1 {
+		t.Errorf("spool has %d lines, want only the segment younger than an hour", spool.Lines())
+	}
+
+	spool.Write([]string{"new"})
+	if got := readSpool(t, spool); !reflect.DeepEqual(got, []string{"old 1", "new"}) {
+		t.Errorf("replayed %q, want old 1 and new", got)
+	}
+}
+
+func TestSpoolSkipsUnreadableSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	name := fmt.Sprintf("%020d-%06d-%d%s", time.Now().UnixNano(), 0, 1, spoolSegmentExtension)
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("not quoted\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.seg"), []byte("\"ignored\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spool, err := OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpool() returned %v", err)
+	}
+	spool.Write([]string{"good"})
+
+	if got := readSpool(t, spool); !reflect.DeepEqual(got, []string{"good"}) {
+		t.Errorf("replayed %q, want the segment after the unreadable one", got)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	InputModuleKind     = "input"
+	TransformModuleKind = "transform"
+	OutputModuleKind    = "output"
+)
+
+// ModuleState is a snapshot of the most recent run of a module
+type ModuleState struct {
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`
+	Runs     int64     `json:"runs"`
+	LastRun  time.Time `json:"last_run"`
+	Duration float64   `json:"duration_seconds"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// ModuleStatus tracks the runs of a module so they can be reported by the http api.  It is safe to
+// use from multiple goroutines.
+type ModuleStatus struct {
+	lock  sync.RWMutex
+	state ModuleState
+}
+
+func NewModuleStatus(name string, kind string) *ModuleStatus {
+	return &ModuleStatus{state: ModuleState{Name: name, Kind: kind}}
+}
+
+// Record saves the result of a module run that began at start
+func (s *ModuleStatus) Record(start time.Time, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.state.Runs++
+	s.state.LastRun = start
+	s.state.Duration = time.Since(start).Seconds()
+	s.state.Error = ""
+	if err != nil {
+		s.state.Error = err.Error()
+	}
+}
+
+// State returns a copy of the current module state
+func (s *ModuleStatus) State() ModuleState {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.state
+}
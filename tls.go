@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// tlsSettings are the settings accepted by every module that can connect with tls, registered along
+// with the rest of the module's settings.
+var tlsSettings = []ModuleSetting{
+	{"tls", "connect with tls"},
+	{"tls_ca_file", "pem file of the certificate authorities to verify the server with, the system roots by default"},
+	{"tls_cert_file", "pem client certificate for mutual tls"},
+	{"tls_key_file", "pem key of the client certificate"},
+	{"tls_server_name", "server name to verify, the host connected to by default"},
+	{"tls_insecure_skip_verify", "don't verify the server certificate, only for testing"},
+}
+
+// newTLSConfig builds the tls config for a module from its tls settings.  It returns nil if tls isn't
+// enabled.
+func newTLSConfig(moduleConfig *ModuleConfig) (*tls.Config, error) {
+	enabled, _ := moduleConfig.SettingsBool("tls")
+	if !enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	caFile, err := moduleConfig.SettingsString("tls_ca_file")
+	if err == nil && caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	certFile, _ := moduleConfig.SettingsString("tls_cert_file")
+	keyFile, _ := moduleConfig.SettingsString("tls_key_file")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("tls_cert_file and tls_key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	serverName, err := moduleConfig.SettingsString("tls_server_name")
+	if err == nil {
+		tlsConfig.ServerName = serverName
+	}
+
+	tlsConfig.InsecureSkipVerify, _ = moduleConfig.SettingsBool("tls_insecure_skip_verify")
+
+	return tlsConfig, nil
+}
+
+// dialTimeout connects to the address, completing a tls handshake when tlsConfig isn't nil.  The
+// timeout covers both the connection and the handshake.  Unless the config names a server, the
+// certificate is verified against the host being connected to.
+func dialTimeout(network string, address string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil || tlsConfig == nil {
+		return conn, err
+	}
+
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key signed by a test certificate authority, or the authority itself
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+func newTestCert(t *testing.T, dir string, name string, parent *testCert, template *x509.Certificate) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	err = ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// startTLSServer accepts connections on localhost and greets each client that completes a handshake.
+// Client certificates are required when clientCAs isn't nil.
+func startTLSServer(t *testing.T, server *testCert, clientCAs *x509.CertPool) string {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.cert.Raw}, PrivateKey: server.key}},
+	}
+	if clientCAs != nil {
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if conn.(*tls.Conn).Handshake() == nil {
+					conn.Write([]byte("hello\n"))
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestTLSConnections(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	server := newTestCert(t, dir, "server", ca, &x509.Certificate{
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	client := newTestCert(t, dir, "client", ca, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	// the server certificate is only valid for localhost, not the ip it listens on
+	_, port, _ := net.SplitHostPort(startTLSServer(t, server, nil))
+	_, mutualPort, _ := net.SplitHostPort(startTLSServer(t, server, clientCAs))
+
+	tests := []struct {
+		name     string
+		address  string
+		settings map[string]interface{}
+		err      string
+	}{
+		{
+			name:     "verified with the ca",
+			address:  "localhost:" + port,
+			settings: map[string]interface{}{"tls_ca_file": ca.certFile},
+		},
+		{
+			name:    "unknown authority",
+			address: "localhost:" + port,
+			err:     "certificate",
+		},
+		{
+			name:     "insecure skip verify",
+			address:  "localhost:" + port,
+			settings: map[string]interface{}{"tls_insecure_skip_verify": true},
+		},
+		{
+			name:     "server name defaults to the host",
+			address:  "127.0.0.1:" + port,
+			settings: map[string]interface{}{"tls_ca_file": ca.certFile},
+			err:      "127.0.0.1",
+		},
+		{
+			name:     "server name",
+			address:  "127.0.0.1:" + port,
+			settings: map[string]interface{}{"tls_ca_file": ca.certFile, "tls_server_name": "localhost"},
+		},
+		{
+			name:     "client certificate",
+			address:  "localhost:" + mutualPort,
+			settings: map[string]interface{}{"tls_ca_file": ca.certFile, "tls_cert_file": client.certFile, "tls_key_file": client.keyFile},
+		},
+		{
+			name:     "missing client certificate",
+			address:  "localhost:" + mutualPort,
+			settings: map[string]interface{}{"tls_ca_file": ca.certFile},
+			err:      "certificate",
+		},
+	}
+
+	for _, test := range tests {
+		settings := map[string]interface{}{"tls": true}
+		for key, value := range test.settings {
+			settings[key] = value
+		}
+
+		tlsConfig, err := newTLSConfig(&ModuleConfig{Settings: settings})
+		if err != nil {
+			t.Errorf("%s: newTLSConfig() returned %v", test.name, err)
+			continue
+		}
+
+		greeting := ""
+		conn, err := dialTimeout("tcp", test.address, 5*time.Second, tlsConfig)
+		if err == nil {
+			// a server rejects a missing client certificate after the client's side of a tls 1.3
+			// handshake is done, so the error only shows up on the first read
+			greeting, err = bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+		}
+
+		if test.err == "" {
+			if err != nil || greeting != "hello\n" {
+				t.Errorf("%s: got %q, %v, want the server greeting", test.name, greeting, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want one mentioning %q", test.name, err, test.err)
+		}
+	}
+
+	// the server name given to the handshake doesn't change the config the module keeps
+	tlsConfig, _ := newTLSConfig(&ModuleConfig{Settings: map[string]interface{}{"tls": true, "tls_ca_file": ca.certFile}})
+	conn, err := dialTimeout("tcp", "localhost:"+port, 5*time.Second, tlsConfig)
+	if err != nil {
+		t.Fatalf("dialTimeout() returned %v", err)
+	}
+	conn.Close()
+	if tlsConfig.ServerName != "" {
+		t.Errorf("the module's tls config was changed to server name %s", tlsConfig.ServerName)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	notPem := filepath.Join(dir, "not.pem")
+	ioutil.WriteFile(notPem, []byte("not a certificate"), 0644)
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		err      bool
+	}{
+		{"tls disabled", map[string]interface{}{"tls_ca_file": notPem}, false},
+		{"no certificates in the ca file", map[string]interface{}{"tls": true, "tls_ca_file": notPem}, true},
+		{"missing ca file", map[string]interface{}{"tls": true, "tls_ca_file": filepath.Join(dir, "missing.pem")}, true},
+		{"cert without a key", map[string]interface{}{"tls": true, "tls_cert_file": notPem}, true},
+		{"key without a cert", map[string]interface{}{"tls": true, "tls_key_file": notPem}, true},
+	}
+
+	for _, test := range tests {
+		tlsConfig, err := newTLSConfig(&ModuleConfig{Settings: test.settings})
+		if test.err && err == nil {
+			t.Errorf("%s: newTLSConfig() didn't return an error", test.name)
+		}
+		if !test.err && (err != nil || tlsConfig != nil) {
+			t.Errorf("%s: newTLSConfig() = %v, %v, want no config", test.name, tlsConfig, err)
+		}
+	}
+}